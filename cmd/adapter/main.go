@@ -1,64 +1,258 @@
 package main
 
 import (
+    "context"
+    "crypto/tls"
     "errors"
+    "fmt"
     "io"
     "log"
+    "net"
     "net/http"
     "os"
+    "os/signal"
     "path/filepath"
+    "runtime"
+    "strconv"
     "strings"
+    "syscall"
+    "time"
 
     "claude-openai-adapter/pkg/adapterhttp"
     apilog "claude-openai-adapter/pkg/logging"
 )
 
+// Set at build time: go build -ldflags "-X main.version=v1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)".
+var (
+    version   = "dev"
+    commit    = ""
+    buildDate = ""
+)
+
 func env(key, def string) string { v := os.Getenv(key); if v == "" { return def }; return v }
 
+func envBool(key string) bool {
+    switch strings.ToLower(strings.TrimSpace(os.Getenv(key))) {
+    case "1", "true", "yes": return true
+    }
+    return false
+}
+
+func envInt(key string, def int) int {
+    v, err := strconv.Atoi(strings.TrimSpace(os.Getenv(key)))
+    if err != nil { return def }
+    return v
+}
+
+// envDuration accepts a Go duration ("15s") or a whole number of seconds.
+func envDuration(key string, def time.Duration) time.Duration {
+    v := strings.TrimSpace(os.Getenv(key))
+    if v == "" { return def }
+    if n, err := strconv.Atoi(v); err == nil { return time.Duration(n) * time.Second }
+    if d, err := time.ParseDuration(v); err == nil { return d }
+    return def
+}
+
 func healthHandler(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK); _, _ = w.Write([]byte("ok\n")) }
 
-func setupLogger() {
+// logLocation reads ADAPTER_LOG_TZ ("Local" or an IANA name); unset or invalid means UTC.
+func logLocation() *time.Location {
+    name := strings.TrimSpace(os.Getenv("ADAPTER_LOG_TZ"))
+    if name == "" { return time.UTC }
+    loc, err := time.LoadLocation(name)
+    if err != nil { log.Printf("ADAPTER_LOG_TZ %q: %v; using UTC", name, err); return time.UTC }
+    return loc
+}
+
+// forwardHeaders reads ADAPTER_FORWARD_HEADERS, a comma-separated header list;
+// unset keeps the adapter's default list and "none" forwards nothing.
+func forwardHeaders() []string {
+    v := strings.TrimSpace(os.Getenv("ADAPTER_FORWARD_HEADERS"))
+    if v == "" { return nil }
+    out := []string{}
+    if strings.EqualFold(v, "none") { return out }
+    for _, h := range strings.Split(v, ",") {
+        if h = strings.TrimSpace(h); h != "" { out = append(out, h) }
+    }
+    return out
+}
+
+// socketMode reads ADAPTER_SOCKET_MODE as octal permissions (default 0660).
+func socketMode() os.FileMode {
+    v := strings.TrimSpace(os.Getenv("ADAPTER_SOCKET_MODE"))
+    m, err := strconv.ParseUint(v, 8, 32)
+    if v == "" || err != nil { return 0o660 }
+    return os.FileMode(m)
+}
+
+// listen binds addr: "unix:/path/to.sock" for a unix socket (chmod-ed to mode),
+// "host:port", or a bare port. It returns the listener and a printable address.
+// A stale socket file left by an unclean exit is replaced; closing the listener
+// (srv.Shutdown does) removes the socket file.
+func listen(addr string, mode os.FileMode) (net.Listener, string, error) {
+    if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+        if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 { _ = os.Remove(path) }
+        ln, err := net.Listen("unix", path)
+        if err != nil { return nil, "", err }
+        if err := os.Chmod(path, mode); err != nil { ln.Close(); return nil, "", err }
+        return ln, addr, nil
+    }
+    if !strings.Contains(addr, ":") { addr = ":" + addr }
+    ln, err := net.Listen("tcp", addr)
+    if err != nil { return nil, "", err }
+    return ln, addr, nil
+}
+
+// serverTLS builds the TLS config from ADAPTER_TLS_CERT and ADAPTER_TLS_KEY
+// (PEM files) and ADAPTER_TLS_MIN_VERSION ("1.2" default, or "1.3"). It returns
+// nil when neither file is set, meaning plain HTTP.
+func serverTLS() (*tls.Config, error) {
+    cert, key := os.Getenv("ADAPTER_TLS_CERT"), os.Getenv("ADAPTER_TLS_KEY")
+    if cert == "" && key == "" { return nil, nil }
+    if cert == "" || key == "" { return nil, errors.New("ADAPTER_TLS_CERT and ADAPTER_TLS_KEY must be set together") }
+    pair, err := tls.LoadX509KeyPair(cert, key)
+    if err != nil { return nil, err }
+    min := uint16(tls.VersionTLS12)
+    switch v := strings.TrimSpace(os.Getenv("ADAPTER_TLS_MIN_VERSION")); v {
+    case "", "1.2":
+    case "1.3": min = tls.VersionTLS13
+    default: return nil, fmt.Errorf("ADAPTER_TLS_MIN_VERSION %q: want 1.2 or 1.3", v)
+    }
+    return &tls.Config{Certificates: []tls.Certificate{pair}, MinVersion: min}, nil
+}
+
+// envList reads a comma-separated env var, skipping empty entries.
+func envList(key string) []string {
+    var out []string
+    for _, v := range strings.Split(os.Getenv(key), ",") {
+        if v = strings.TrimSpace(v); v != "" { out = append(out, v) }
+    }
+    return out
+}
+
+// setupLogger configures logging and returns a func that closes the log file, if any.
+func setupLogger() (closeLog func()) {
     level := strings.ToLower(env("ADAPTER_LOG_LEVEL", "info"))
     logPath := strings.TrimSpace(os.Getenv("ADAPTER_LOG_FILE"))
     var out io.Writer = os.Stdout
+    closeLog = func() {}
     if logPath != "" && logPath != "-" {
         // ensure directory exists
         _ = os.MkdirAll(filepath.Dir(logPath), 0o755)
-        rot, err := apilog.NewRotatingWriter(logPath, 300*1024*1024) // 300MB per file
+        rot, err := apilog.NewRotatingWriterOptions(logPath, apilog.RotatingOptions{
+            MaxBytes:      300 * 1024 * 1024, // 300MB per file
+            RetentionDays: envInt("ADAPTER_LOG_RETENTION_DAYS", 0),
+            MaxTotalBytes: int64(envInt("ADAPTER_LOG_MAX_TOTAL_BYTES", 0)),
+            Location:      logLocation(),
+            Symlink:       runtime.GOOS != "windows" && !envBool("ADAPTER_LOG_POINTER_FILE"),
+        })
         if err == nil {
             out = io.MultiWriter(os.Stdout, rot)
+            closeLog = func() { log.SetOutput(os.Stdout); _ = rot.Close() }
         }
     }
     log.SetOutput(out)
     log.SetFlags(log.LstdFlags | log.Lmicroseconds)
-    if level == "debug" {
+    if level == "debug" || envBool("ADAPTER_DEBUG") {
         adapterhttp.SetDebug(true)
     }
     if strings.ToLower(strings.TrimSpace(env("ADAPTER_LOG_EVENTS", ""))) == "true" || env("ADAPTER_LOG_EVENTS", "") == "1" {
         adapterhttp.SetLogEvents(true)
     }
+    if strings.EqualFold(strings.TrimSpace(os.Getenv("ADAPTER_LOG_FORMAT")), "json") { adapterhttp.SetLogJSON(true) }
+    return closeLog
+}
+
+// setupTranscript opens ADAPTER_TRANSCRIPT_FILE, if set, as a rotating JSONL
+// transcript; the returned func flushes and closes it.
+func setupTranscript() (*adapterhttp.Transcript, func()) {
+    path := os.Getenv("ADAPTER_TRANSCRIPT_FILE")
+    if path == "" { return nil, func() {} }
+    rot, err := apilog.NewRotatingWriterOptions(path, apilog.RotatingOptions{
+        MaxBytes: 300 * 1024 * 1024,
+        Location: logLocation(),
+        Symlink:  runtime.GOOS != "windows" && !envBool("ADAPTER_LOG_POINTER_FILE"),
+        Compress: envBool("ADAPTER_TRANSCRIPT_COMPRESS"),
+    })
+    if err != nil { log.Printf("transcript disabled: %v", err); return nil, func() {} }
+    t := adapterhttp.NewTranscript(rot)
+    return t, func() { _ = t.Close(); _ = rot.Close() }
 }
 
 func main() {
-    setupLogger()
+    closeLog := setupLogger()
+    defer closeLog()
+    transcript, closeTranscript := setupTranscript()
+    defer closeTranscript()
     cfg := adapterhttp.Config{
-        AnthropicBaseURL:   env("ANTHROPIC_BASE_URL", "https://api.anthropic.com"),
-        AnthropicAPIKey:    os.Getenv("ANTHROPIC_API_KEY"),
-        AnthropicVersion:   env("ANTHROPIC_VERSION", "2023-06-01"),
-        OpenAIBaseURL:      env("OPENAI_BASE_URL", "https://api.openai.com"),
-        OpenAIAPIKey:       os.Getenv("OPENAI_API_KEY"),
-        ModelMap:           os.Getenv("MODEL_MAP"),
-        DefaultOpenAIModel: env("OPENAI_MODEL", "gpt-4o-mini"),
+        AnthropicBaseURL:      env("ANTHROPIC_BASE_URL", "https://api.anthropic.com"),
+        AnthropicAPIKey:       os.Getenv("ANTHROPIC_API_KEY"),
+        AnthropicVersion:      env("ANTHROPIC_VERSION", "2023-06-01"),
+        OpenAIBaseURL:         env("OPENAI_BASE_URL", "https://api.openai.com"),
+        OpenAIAPIKey:          os.Getenv("OPENAI_API_KEY"),
+        AzureOpenAI:           envBool("AZURE_OPENAI"),
+        AzureAPIVersion:       os.Getenv("AZURE_API_VERSION"),
+        ModelMap:              os.Getenv("MODEL_MAP"),
+        DefaultOpenAIModel:    env("OPENAI_MODEL", "gpt-4o-mini"),
+        ReverseModelMap:       os.Getenv("REVERSE_MODEL_MAP"),
+        DefaultAnthropicModel: os.Getenv("ANTHROPIC_MODEL"),
+        RedactContent:         envBool("ADAPTER_REDACT_CONTENT"),
+        MaxToolCallsPerTurn:   envInt("ADAPTER_MAX_TOOL_CALLS", 0),
+        FailOnMaxToolCalls:    envBool("ADAPTER_MAX_TOOL_CALLS_ERROR"),
+        MaxStopSequences:      envInt("ADAPTER_MAX_STOP_SEQUENCES", 0),
+        NormalizeToolPaths:    envBool("ADAPTER_NORMALIZE_TOOL_PATHS"),
+        ReportUpstreamModel:   envBool("ADAPTER_REPORT_UPSTREAM_MODEL"),
+        PlaceholderUserText:   os.Getenv("ADAPTER_PLACEHOLDER_USER_TEXT"),
+        OverloadedStatus:      envInt("ADAPTER_OVERLOADED_STATUS", 0),
+        DisableKeepAlives:     envBool("ADAPTER_DISABLE_KEEPALIVES"),
+        ScaleTemperature:      envBool("ADAPTER_SCALE_TEMPERATURE"),
+        TrimSystem:            envBool("ADAPTER_TRIM_SYSTEM"),
+        MaxImagePixels:        envInt("ADAPTER_MAX_IMAGE_PIXELS", 0),
+        JSONModePrompt:        envBool("ADAPTER_JSON_MODE_PROMPT"),
+        EmptyAssistantText:    os.Getenv("ADAPTER_EMPTY_ASSISTANT_TEXT"),
+        AnthropicSSECompat:    envBool("ADAPTER_ANTHROPIC_SSE_COMPAT"),
+        StructuredContent:     envBool("ADAPTER_STRUCTURED_CONTENT"),
+        Transcript:            transcript,
+        TranscriptRaw:         envBool("ADAPTER_TRANSCRIPT_RAW"),
+        MaxRequestBytes:       int64(envInt("ADAPTER_MAX_BODY_BYTES", 0)),
+        ForwardHeaders:        forwardHeaders(),
+        Limiter:               adapterhttp.NewLimiter(envInt("ADAPTER_MAX_CONCURRENCY", 0), envDuration("ADAPTER_CONCURRENCY_WAIT", 0)),
+        Breaker:               adapterhttp.NewBreaker(envInt("ADAPTER_BREAKER_THRESHOLD", 0), envDuration("ADAPTER_BREAKER_COOLDOWN", 0)),
+        SSEMaxLine:            envInt("ADAPTER_SSE_MAX_LINE", 0),
+        SSEPingInterval:       envDuration("ADAPTER_SSE_PING_INTERVAL", 0),
     }
 
-    client := http.DefaultClient
+    client := adapterhttp.NewUpstreamClient(cfg)
     mux := http.NewServeMux()
     mux.HandleFunc("/health", healthHandler)
+    mux.Handle("/ready", adapterhttp.NewReadyHandler(cfg, client))
+    mux.Handle("/metrics", adapterhttp.MetricsHandler())
+    mux.Handle("/version", adapterhttp.NewVersionHandler(cfg, adapterhttp.BuildInfo{Version: version, Commit: commit, BuildDate: buildDate}))
     mux.Handle("/v1/messages", adapterhttp.NewMessagesHandler(cfg, client))
     mux.Handle("/v1/chat/completions", adapterhttp.NewChatCompletionsHandler(cfg, client))
+    if envBool("ADAPTER_DEBUG_CONVERT") { mux.Handle("/v1/convert", adapterhttp.NewConvertHandler(cfg)) }
 
-    port := env("ADAPTER_LISTEN", env("PORT", "8080"))
-    srv := &http.Server{ Addr: ":" + port, Handler: adapterhttp.Logging(mux) }
-    log.Printf("Claude<->OpenAI adapter listening on :%s", port)
-    if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) { log.Fatal(err) }
+    ln, addr, err := listen(env("ADAPTER_LISTEN", env("PORT", "8080")), socketMode())
+    if err != nil { closeLog(); log.Fatal(err) }
+    tlsCfg, err := serverTLS()
+    if err != nil { closeLog(); log.Fatal(err) }
+    srv := &http.Server{ Handler: adapterhttp.RequestID(adapterhttp.Logging(adapterhttp.CORS(envList("ADAPTER_CORS_ORIGINS"), adapterhttp.Auth(envList("ADAPTER_AUTH_TOKEN"), mux)))), TLSConfig: tlsCfg }
+    // On SIGINT/SIGTERM let in-flight requests finish, then fall through to close the log file.
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+    if d := envDuration("ADAPTER_METRICS_LOG_INTERVAL", 0); d > 0 { go adapterhttp.LogMetricsSummary(ctx, d) }
+    done := make(chan struct{}) // closed once Shutdown has drained in-flight requests
+    go func() {
+        defer close(done)
+        <-ctx.Done()
+        shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+        defer cancel()
+        _ = srv.Shutdown(shutdownCtx)
+    }()
+    log.Printf("Claude<->OpenAI adapter listening on %s (tls=%v)", addr, tlsCfg != nil)
+    serve := srv.Serve
+    if tlsCfg != nil { serve = func(ln net.Listener) error { return srv.ServeTLS(ln, "", "") } }
+    if err := serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) { closeLog(); log.Fatal(err) }
+    <-done
+    log.Printf("adapter stopped")
 }
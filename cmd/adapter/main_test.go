@@ -0,0 +1,81 @@
+package main
+
+import (
+    "context"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "io"
+    "math/big"
+    "net"
+    "net/http"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestListen_UnixSocket(t *testing.T) {
+    sock := filepath.Join(t.TempDir(), "adapter.sock")
+    if err := os.WriteFile(sock, nil, 0o600); err != nil { t.Fatal(err) }
+    if _, _, err := listen("unix:"+sock, 0o600); err == nil { t.Fatalf("listen over a regular file succeeded") }
+    _ = os.Remove(sock)
+
+    ln, addr, err := listen("unix:"+sock, 0o600)
+    if err != nil { t.Fatalf("listen: %v", err) }
+    if addr != "unix:"+sock { t.Fatalf("addr %q", addr) }
+    fi, err := os.Stat(sock)
+    if err != nil || fi.Mode().Perm() != 0o600 { t.Fatalf("socket mode: %v %v", fi, err) }
+
+    srv := &http.Server{Handler: http.HandlerFunc(healthHandler)}
+    go func() { _ = srv.Serve(ln) }()
+    client := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+        return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+    }}}
+    resp, err := client.Get("http://adapter/health")
+    if err != nil { t.Fatalf("request over socket: %v", err) }
+    body, _ := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK || string(body) != "ok\n" { t.Fatalf("response %d %q", resp.StatusCode, body) }
+
+    _ = srv.Shutdown(context.Background())
+    if _, err := os.Stat(sock); !os.IsNotExist(err) { t.Fatalf("socket left behind after shutdown: %v", err) }
+}
+
+// writeSelfSigned writes a throwaway certificate and key as PEM files.
+func writeSelfSigned(t *testing.T) (certFile, keyFile string) {
+    t.Helper()
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil { t.Fatal(err) }
+    tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "localhost"}, DNSNames: []string{"localhost"}, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+    der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+    if err != nil { t.Fatal(err) }
+    keyDER, err := x509.MarshalECPrivateKey(key)
+    if err != nil { t.Fatal(err) }
+    dir := t.TempDir()
+    certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+    _ = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+    _ = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+    return certFile, keyFile
+}
+
+func TestServerTLS(t *testing.T) {
+    t.Setenv("ADAPTER_TLS_CERT", "")
+    t.Setenv("ADAPTER_TLS_KEY", "")
+    if cfg, err := serverTLS(); cfg != nil || err != nil { t.Fatalf("plain HTTP by default: %v %v", cfg, err) }
+
+    cert, key := writeSelfSigned(t)
+    t.Setenv("ADAPTER_TLS_CERT", cert)
+    if _, err := serverTLS(); err == nil { t.Fatalf("cert without key accepted") }
+    t.Setenv("ADAPTER_TLS_KEY", key)
+    cfg, err := serverTLS()
+    if err != nil || cfg == nil || len(cfg.Certificates) != 1 || cfg.MinVersion != tls.VersionTLS12 { t.Fatalf("tls config: %+v %v", cfg, err) }
+    t.Setenv("ADAPTER_TLS_MIN_VERSION", "1.3")
+    if cfg, err = serverTLS(); err != nil || cfg.MinVersion != tls.VersionTLS13 { t.Fatalf("min version: %+v %v", cfg, err) }
+    t.Setenv("ADAPTER_TLS_MIN_VERSION", "1.0")
+    if _, err := serverTLS(); err == nil { t.Fatalf("TLS 1.0 accepted") }
+}
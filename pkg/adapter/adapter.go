@@ -1,28 +1,40 @@
 package adapter
 
 import (
-    "bufio"
     "context"
     "encoding/json"
     "errors"
     "fmt"
+    "hash/fnv"
     "io"
-    "sort"
     "strings"
     "time"
+    "unicode"
 )
 
 // ============ Anthropic (Claude) message API shapes (subset) ============
 
 type AnthropicMessageRequest struct {
-    Model         string            `json:"model"`
-    System        json.RawMessage   `json:"system,omitempty"`
-    Messages      []AnthropicMsg    `json:"messages"`
-    Tools         []AnthropicTool   `json:"tools,omitempty"`
-    MaxTokens     int               `json:"max_tokens,omitempty"`
-    Temperature   *float64          `json:"temperature,omitempty"`
-    StopSequences []string          `json:"stop_sequences,omitempty"`
-    Stream        bool              `json:"stream,omitempty"`
+    Model         string                     `json:"model"`
+    System        json.RawMessage            `json:"system,omitempty"`
+    Messages      []AnthropicMsg             `json:"messages"`
+    Tools         []AnthropicTool            `json:"tools,omitempty"`
+    ToolChoice    *AnthropicToolChoice       `json:"tool_choice,omitempty"`
+    MaxTokens     int                        `json:"max_tokens,omitempty"`
+    Temperature   *float64                   `json:"temperature,omitempty"`
+    TopP          *float64                   `json:"top_p,omitempty"`
+    TopK          *int                       `json:"top_k,omitempty"`
+    Seed          *int                       `json:"seed,omitempty"` // not an Anthropic field; accepted so it can reach OpenAI
+    StopSequences []string                   `json:"stop_sequences,omitempty"`
+    Stream        bool                       `json:"stream,omitempty"`
+    Metadata      json.RawMessage            `json:"metadata,omitempty"` // Anthropic accepts only user_id; clients send more
+    Extra         map[string]json.RawMessage `json:"-"` // unmodeled fields, see extra.go
+}
+
+type AnthropicToolChoice struct {
+    Type                   string `json:"type"` // "auto", "any", "tool" or "none"
+    Name                   string `json:"name,omitempty"`
+    DisableParallelToolUse bool   `json:"disable_parallel_tool_use,omitempty"`
 }
 
 type AnthropicMsg struct {
@@ -31,21 +43,34 @@ type AnthropicMsg struct {
 }
 
 type AnthropicContent struct {
-    Type       string           `json:"type"`          // text | tool_use | tool_result
-    Text       string           `json:"text,omitempty"` // text
+    Type         string                 `json:"type"`           // text | tool_use | tool_result
+    Text         string                 `json:"text,omitempty"` // text
     // tool_use
-    ID         string           `json:"id,omitempty"`
-    Name       string           `json:"name,omitempty"`
-    Input      *json.RawMessage `json:"input,omitempty"`
+    ID           string                 `json:"id,omitempty"`
+    Name         string                 `json:"name,omitempty"`
+    Input        *json.RawMessage       `json:"input,omitempty"`
     // tool_result
-    ToolUseID  string           `json:"tool_use_id,omitempty"`
-    Content    interface{}      `json:"content,omitempty"` // usually string
+    ToolUseID    string                 `json:"tool_use_id,omitempty"`
+    Content      interface{}            `json:"content,omitempty"` // usually string
+    // thinking
+    Thinking     string                 `json:"thinking,omitempty"`
+    Signature    string                 `json:"signature,omitempty"`
+    // any block: prompt caching breakpoint
+    CacheControl *AnthropicCacheControl `json:"cache_control,omitempty"`
 }
 
 type AnthropicTool struct {
-    Name        string                 `json:"name"`
-    Description string                 `json:"description,omitempty"`
-    InputSchema map[string]interface{} `json:"input_schema"`
+    Type         string                 `json:"type,omitempty"` // custom (default) or a built-in tool type
+    Name         string                 `json:"name"`
+    Description  string                 `json:"description,omitempty"`
+    InputSchema  map[string]interface{} `json:"input_schema"`
+    CacheControl *AnthropicCacheControl `json:"cache_control,omitempty"`
+}
+
+// AnthropicCacheControl marks a prompt caching breakpoint: {"type":"ephemeral"}.
+type AnthropicCacheControl struct {
+    Type string `json:"type"`
+    TTL  string `json:"ttl,omitempty"` // "5m" (default) or "1h"
 }
 
 // Response (non-stream)
@@ -68,26 +93,62 @@ type AnthropicUsage struct {
 // ============ OpenAI Chat Completions shapes (subset) ============
 
 type OpenAIChatRequest struct {
-    Model       string           `json:"model"`
-    Messages    []OpenAIMessage  `json:"messages"`
-    Tools       []OpenAITool     `json:"tools,omitempty"`
-    Temperature *float64         `json:"temperature,omitempty"`
-    MaxTokens   int              `json:"max_tokens,omitempty"`
-    Stop        []string         `json:"stop,omitempty"`
-    Stream      bool             `json:"stream,omitempty"`
+    Model               string                     `json:"model"`
+    Messages            []OpenAIMessage            `json:"messages"`
+    Tools               []OpenAITool               `json:"tools,omitempty"`
+    Functions           []OpenAIFunction           `json:"functions,omitempty"`             // legacy form of tools
+    FunctionCall        json.RawMessage            `json:"function_call,omitempty"`         // legacy form of tool_choice: "none", "auto" or {"name": ...}
+    Temperature         *float64                   `json:"temperature,omitempty"`
+    TopP                *float64                   `json:"top_p,omitempty"`
+    MaxTokens           int                        `json:"max_tokens,omitempty"`
+    MaxCompletionTokens int                        `json:"max_completion_tokens,omitempty"` // newer name for max_tokens
+    ToolChoice          json.RawMessage            `json:"tool_choice,omitempty"`           // "none", "auto", "required" or {"type":"function","function":{"name": ...}}
+    User                string                     `json:"user,omitempty"`                  // end-user id; Anthropic's metadata.user_id
+    Stop                []string                   `json:"stop,omitempty"`
+    PresencePenalty     *float64                   `json:"presence_penalty,omitempty"`      // no Anthropic equivalent; dropped
+    FrequencyPenalty    *float64                   `json:"frequency_penalty,omitempty"`     // no Anthropic equivalent; dropped
+    Seed                *int                       `json:"seed,omitempty"`                  // no Anthropic equivalent; dropped
+    N                   *int                       `json:"n,omitempty"`                     // choices to generate; only 1 is supported
+    Stream              bool                       `json:"stream,omitempty"`
+    StreamOptions       *OpenAIStreamOptions       `json:"stream_options,omitempty"`
+    ResponseFormat      *OpenAIResponseFormat      `json:"response_format,omitempty"`
+    ParallelToolCalls   *bool                      `json:"parallel_tool_calls,omitempty"`
+    Metadata            map[string]string          `json:"metadata,omitempty"`
+    Extra               map[string]json.RawMessage `json:"-"`                               // unmodeled fields, see extra.go
+}
+
+type OpenAIStreamOptions struct {
+    IncludeUsage bool `json:"include_usage,omitempty"` // ask for a final usage-only chunk
+}
+
+type OpenAIResponseFormat struct {
+    Type       string            `json:"type"` // "text", "json_object" or "json_schema"
+    JSONSchema *OpenAIJSONSchema `json:"json_schema,omitempty"`
+}
+
+type OpenAIJSONSchema struct {
+    Name        string                 `json:"name"`
+    Description string                 `json:"description,omitempty"`
+    Schema      map[string]interface{} `json:"schema,omitempty"`
+    Strict      *bool                  `json:"strict,omitempty"`
 }
 
 type OpenAIMessage struct {
-    Role       string           `json:"role"`
-    Content    interface{}      `json:"content,omitempty"`      // string or []parts
-    Name       string           `json:"name,omitempty"`
-    ToolCallID string           `json:"tool_call_id,omitempty"` // for role=tool
-    ToolCalls  []OpenAIToolCall `json:"tool_calls,omitempty"`   // for assistant
+    Role               string                  `json:"role"`
+    Content            interface{}             `json:"content,omitempty"`             // string or []parts
+    Name               string                  `json:"name,omitempty"`
+    ToolCallID         string                  `json:"tool_call_id,omitempty"`        // for role=tool
+    ToolCalls          []OpenAIToolCall        `json:"tool_calls,omitempty"`          // for assistant
+    Refusal            string                  `json:"refusal,omitempty"`             // assistant declined; set instead of content
+    FunctionCall       *OpenAIToolCallFunction `json:"function_call,omitempty"`       // legacy single call, answered by a role=function message
+    ReasoningContent   string                  `json:"reasoning_content,omitempty"`   // assistant reasoning; Anthropic thinking
+    ReasoningSignature string                  `json:"reasoning_signature,omitempty"` // signature of the Anthropic thinking block, to send back on later turns
 }
 
 type OpenAITool struct {
-    Type     string         `json:"type"` // "function"
-    Function OpenAIFunction `json:"function"`
+    Type         string                 `json:"type"` // "function"
+    Function     OpenAIFunction         `json:"function"`
+    CacheControl *AnthropicCacheControl `json:"cache_control,omitempty"` // accepted from clients that mark tools for Anthropic prompt caching
 }
 
 type OpenAIFunction struct {
@@ -131,9 +192,11 @@ type OpenAIStreamChunk struct {
     Choices []struct {
         Index int `json:"index"`
         Delta struct {
-            Role      string           `json:"role,omitempty"`
-            Content   string           `json:"content,omitempty"`
-            ToolCalls []struct {
+            Role               string `json:"role,omitempty"`
+            Content            string `json:"content,omitempty"`
+            ReasoningContent   string `json:"reasoning_content,omitempty"`
+            ReasoningSignature string `json:"reasoning_signature,omitempty"`
+            ToolCalls          []struct {
                 ID       string `json:"id,omitempty"`
                 Type     string `json:"type"`
                 Index    int    `json:"index"`
@@ -147,8 +210,179 @@ type OpenAIStreamChunk struct {
     } `json:"choices"`
 }
 
+// ============ Conversion options ============
+
+// Options tunes conversions. The zero value keeps the default behavior.
+type Options struct {
+    // Warn, when set, is told about lossy or corrective conversion steps.
+    Warn func(kind, detail string)
+    // MaxToolCalls caps tool calls in a single assistant turn; 0 means unlimited.
+    // Extras are dropped with a warning unless FailOnMaxToolCalls is set.
+    MaxToolCalls int
+    // FailOnMaxToolCalls turns exceeding MaxToolCalls into ErrTooManyToolCalls.
+    // Streaming converters send an error event at the call that passes the cap and stop.
+    FailOnMaxToolCalls bool
+    // MaxStopSequences caps stop sequences sent to the target provider; 0 uses
+    // the provider default (OpenAIMaxStopSequences toward OpenAI, unlimited toward Anthropic).
+    MaxStopSequences int
+    // LegacyFunctions answers with the pre-tools shape: message.function_call and
+    // finish_reason "function_call", for clients that sent functions instead of
+    // tools. Only the first tool call fits; later ones are dropped with a warning.
+    LegacyFunctions bool
+    // IncludeUsage makes ConvertAnthropicStreamToOpenAI end with a usage-only
+    // chunk (empty choices), as OpenAI does for stream_options.include_usage.
+    IncludeUsage bool
+    // NormalizeToolPaths rewrites backslash separators to "/" in the path and
+    // file_path arguments of tool calls in non-streaming responses.
+    NormalizeToolPaths bool
+    // ReportUpstreamModel makes ConvertOpenAIStreamToAnthropic report the model
+    // named in the upstream chunks, rather than the requested one, in
+    // message_start and message_delta.
+    ReportUpstreamModel bool
+    // PlaceholderUserText is the text of the user message prepended when an
+    // Anthropic conversation would otherwise not start with a user turn; empty uses ".".
+    PlaceholderUserText string
+    // ScaleTemperature maps temperature linearly between the APIs' ranges
+    // (OpenAI 0-2, Anthropic 0-1) instead of only clamping it to the target range.
+    ScaleTemperature bool
+    // TrimSystem trims leading/trailing whitespace from the system prompt.
+    // By default it is forwarded byte for byte (few-shot prompts rely on it).
+    TrimSystem bool
+    // JSONModePrompt turns an OpenAI response_format into a system-prompt
+    // instruction toward Anthropic, which has no JSON mode; otherwise it is dropped.
+    JSONModePrompt bool
+    // EmptyAssistantText is the text sent for an OpenAI assistant turn with no
+    // content and no tool calls (Anthropic rejects empty text blocks). Empty drops
+    // the turn instead, and the user turns around it are merged to keep alternation.
+    EmptyAssistantText string
+    // MaxSSELine caps the size of one upstream SSE line, or one event's data, in
+    // the stream converters; a longer one ends the stream with an error event and
+    // ErrSSELineTooLong. 0 uses DefaultSSEMaxLine.
+    MaxSSELine int
+    // StructuredContent makes AnthropicToOpenAIResponse return the message
+    // content as an array of text parts, one per Anthropic text block, instead
+    // of joining the blocks with blank lines.
+    StructuredContent bool
+}
+
+// OpenAIMaxStopSequences is the most stop sequences the OpenAI API accepts.
+const OpenAIMaxStopSequences = 4
+
+// ErrTooManyToolCalls is returned when a turn exceeds Options.MaxToolCalls
+// and Options.FailOnMaxToolCalls is set.
+var ErrTooManyToolCalls = errors.New("too many tool calls in one turn")
+
+func pickOptions(opts []Options) Options {
+    if len(opts) > 0 { return opts[0] }
+    return Options{}
+}
+
+func (o Options) warn(kind, format string, args ...interface{}) {
+    if o.Warn != nil { o.Warn(kind, fmt.Sprintf(format, args...)) }
+}
+
+// toolCallAllowed reports whether the n-th tool call (0-based) fits under MaxToolCalls,
+// warning about every call that doesn't.
+func (o Options) toolCallAllowed(n int) bool {
+    if o.MaxToolCalls <= 0 || n < o.MaxToolCalls { return true }
+    o.warn("tool_calls_truncated", "tool call %d exceeds limit %d", n+1, o.MaxToolCalls)
+    return false
+}
+
+// capStops truncates stops to max entries (max <= 0 means no cap), warning about the extras.
+func (o Options) capStops(stops []string, max int) []string {
+    if max <= 0 || len(stops) <= max { return stops }
+    o.warn("stop_sequences_truncated", "dropped %d stop sequences beyond limit %d", len(stops)-max, max)
+    return stops[:max]
+}
+
+// dropBlankStops removes empty and whitespace-only stop sequences, which OpenAI
+// tolerates but Anthropic rejects with a 400, warning about each one.
+func (o Options) dropBlankStops(stops []string) []string {
+    var out []string
+    for _, s := range stops {
+        if strings.TrimSpace(s) == "" { o.warn("stop_sequence_dropped", "dropped blank stop sequence %q", s); continue }
+        out = append(out, s)
+    }
+    return out
+}
+
+// jsonModeInstruction renders an OpenAI response_format as a system-prompt
+// instruction, or returns "" (warning unless the format is plain text).
+func (o Options) jsonModeInstruction(rf *OpenAIResponseFormat) string {
+    if rf == nil || rf.Type == "" || rf.Type == "text" { return "" }
+    if !o.JSONModePrompt {
+        o.warn("unsupported_param", "response_format=%s dropped: Anthropic has no JSON mode", rf.Type)
+        return ""
+    }
+    switch rf.Type {
+    case "json_object":
+        return "Respond only with valid JSON."
+    case "json_schema":
+        if rf.JSONSchema == nil || rf.JSONSchema.Schema == nil { return "Respond only with valid JSON." }
+        schema, _ := json.Marshal(rf.JSONSchema.Schema)
+        note := "Respond only with valid JSON matching this JSON schema"
+        if rf.JSONSchema.Name != "" { note += " (" + rf.JSONSchema.Name + ")" }
+        if rf.JSONSchema.Description != "" { note += ", described as: " + rf.JSONSchema.Description }
+        return note + ":\n" + string(schema)
+    }
+    o.warn("unsupported_param", "response_format=%s dropped: unknown type", rf.Type)
+    return ""
+}
+
+// toolPathKeys are the tool arguments NormalizeToolPaths treats as file paths.
+var toolPathKeys = []string{"path", "file_path"}
+
+// normalizePaths returns in with Windows-style separators in its path arguments
+// replaced by "/"; the caller's map is copied rather than modified.
+func (o Options) normalizePaths(in interface{}) interface{} {
+    args, ok := in.(map[string]interface{})
+    if !o.NormalizeToolPaths || !ok { return in }
+    var out map[string]interface{}
+    for _, k := range toolPathKeys {
+        p, ok := args[k].(string)
+        if !ok || !strings.Contains(p, "\\") { continue }
+        if out == nil {
+            out = make(map[string]interface{}, len(args))
+            for kk, v := range args { out[kk] = v }
+        }
+        out[k] = strings.ReplaceAll(p, "\\", "/")
+    }
+    if out == nil { return in }
+    return out
+}
+
+// Temperature ranges accepted by each API.
+const (
+    openAIMaxTemperature    = 2.0
+    anthropicMaxTemperature = 1.0
+)
+
+// convertTemperature moves t from an API whose range is [0, fromMax] to one
+// whose range is [0, toMax]: scaled when ScaleTemperature is set, otherwise
+// clamped. nil stays nil.
+func (o Options) convertTemperature(t *float64, fromMax, toMax float64) *float64 {
+    if t == nil { return nil }
+    v := *t
+    if o.ScaleTemperature { v = v * toMax / fromMax }
+    if v < 0 { v = 0 }
+    if v > toMax {
+        o.warn("temperature_clamped", "temperature %g clamped to %g", v, toMax)
+        v = toMax
+    }
+    return &v
+}
+
 // ============ Utilities & helpers ============
 
+// generatedToolID derives a stable id for a tool call that arrived without one,
+// so converting the same history twice yields the same ids.
+func generatedToolID(msgIdx, callIdx int, name, args string) string {
+    h := fnv.New64a()
+    fmt.Fprintf(h, "%d:%d:%s:%s", msgIdx, callIdx, name, args)
+    return fmt.Sprintf("call_%016x", h.Sum64())
+}
+
 func parseAnthropicContent(raw json.RawMessage) ([]AnthropicContent, bool, error) {
     if len(raw) == 0 || string(raw) == "null" { return nil, false, nil }
     var s string
@@ -162,10 +396,19 @@ func parseAnthropicContent(raw json.RawMessage) ([]AnthropicContent, bool, error
     return nil, false, fmt.Errorf("unsupported content: %s", string(raw))
 }
 
-func mapToolsToOpenAI(tools []AnthropicTool) []OpenAITool {
+// mapToolsToOpenAI maps tools to OpenAI function tools. OpenAI has no built-in
+// tools, so bash and text editor tools are sent as functions with the input schema
+// Anthropic documents for them; other built-in tools are dropped with a warning.
+func (o Options) mapToolsToOpenAI(tools []AnthropicTool) []OpenAITool {
     if len(tools) == 0 { return nil }
     out := make([]OpenAITool, 0, len(tools))
     for _, t := range tools {
+        if t.Type != "" && t.Type != "custom" {
+            schema := builtinToolSchema(t.Type)
+            if schema == nil { o.warn("builtin_tool", "%s tool %q has no OpenAI equivalent; dropped", t.Type, t.Name); continue }
+            o.warn("builtin_tool", "%s tool %q sent to OpenAI as a function with its documented schema", t.Type, t.Name)
+            t.InputSchema = schema
+        }
         out = append(out, OpenAITool{
             Type: "function",
             Function: OpenAIFunction{
@@ -175,9 +418,38 @@ func mapToolsToOpenAI(tools []AnthropicTool) []OpenAITool {
             },
         })
     }
+    if len(out) == 0 { return nil }
     return out
 }
 
+// builtinToolSchema returns the input schema of a client-executed built-in tool
+// type, or nil for types without a known schema (computer use, server tools).
+func builtinToolSchema(typ string) map[string]interface{} {
+    str := map[string]interface{}{"type": "string"}
+    switch {
+    case strings.HasPrefix(typ, "bash_"):
+        return map[string]interface{}{"type": "object", "properties": map[string]interface{}{"command": str, "restart": map[string]interface{}{"type": "boolean"}}}
+    case strings.HasPrefix(typ, "text_editor_"):
+        commands := []interface{}{"view", "create", "str_replace", "insert"}
+        // undo_edit was removed from the Claude 4 editor versions
+        if typ == "text_editor_20241022" || typ == "text_editor_20250124" { commands = append(commands, "undo_edit") }
+        return map[string]interface{}{
+            "type": "object",
+            "properties": map[string]interface{}{
+                "command":     map[string]interface{}{"type": "string", "enum": commands},
+                "path":        str,
+                "file_text":   str,
+                "old_str":     str,
+                "new_str":     str,
+                "insert_line": map[string]interface{}{"type": "integer"},
+                "view_range":  map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "integer"}},
+            },
+            "required": []interface{}{"command", "path"},
+        }
+    }
+    return nil
+}
+
 func systemToOpenAI(sysRaw json.RawMessage) *OpenAIMessage {
     if len(sysRaw) == 0 || string(sysRaw) == "null" { return nil }
     var s string
@@ -200,12 +472,15 @@ func systemToOpenAI(sysRaw json.RawMessage) *OpenAIMessage {
 func ConvertMessagesToOpenAI(req AnthropicMessageRequest) ([]OpenAIMessage, error) {
     var out []OpenAIMessage
     if sm := systemToOpenAI(req.System); sm != nil { out = append(out, *sm) }
-    for _, m := range req.Messages {
+    var genIDs []string // generated tool_use ids still waiting for an id-less tool_result
+    toolNames := map[string]string{} // tool_use id -> tool name, for the name of the matching tool message
+    for mi, m := range req.Messages {
         parts, _, err := parseAnthropicContent(m.Content)
         if err != nil { return nil, err }
         switch m.Role {
         case "user":
             var pendingUserText []string
+            var resultImages []interface{} // images from image-only tool results, sent after the tool messages
             flushUser := func() {
                 if len(pendingUserText) > 0 {
                     out = append(out, OpenAIMessage{Role: "user", Content: strings.Join(pendingUserText, "\n\n")})
@@ -225,28 +500,44 @@ func ConvertMessagesToOpenAI(req AnthropicMessageRequest) ([]OpenAIMessage, erro
                     case nil:
                         contentStr = ""
                     default:
+                        if imgs := imageOnlyParts(v); imgs != nil {
+                            // OpenAI tool messages are text-only: leave a marker and show the image in a user message.
+                            contentStr = "[image result]"
+                            resultImages = append(resultImages, imgs...)
+                            break
+                        }
                         b, _ := json.Marshal(v)
                         contentStr = string(b)
                     }
-                    out = append(out, OpenAIMessage{ Role: "tool", ToolCallID: p.ToolUseID, Content: contentStr })
+                    toolCallID := p.ToolUseID
+                    if toolCallID == "" && len(genIDs) > 0 { toolCallID, genIDs = genIDs[0], genIDs[1:] }
+                    out = append(out, OpenAIMessage{ Role: "tool", ToolCallID: toolCallID, Name: toolNames[toolCallID], Content: contentStr })
                 }
             }
+            if len(resultImages) > 0 { out = append(out, OpenAIMessage{Role: "user", Content: resultImages}) }
             flushUser()
         case "assistant":
             var textBuf []string
             var toolCalls []OpenAIToolCall
-            for _, p := range parts {
+            for pi, p := range parts {
                 switch p.Type {
                 case "text":
                     if p.Text != "" { textBuf = append(textBuf, p.Text) }
                 case "tool_use":
                     args := "{}"
                     if p.Input != nil && *p.Input != nil { args = string(*p.Input) }
-                    toolCalls = append(toolCalls, OpenAIToolCall{ ID: p.ID, Type: "function", Function: OpenAIToolCallFunction{Name: p.Name, Arguments: args} })
+                    id := p.ID
+                    if id == "" { id = generatedToolID(mi, pi, p.Name, args); genIDs = append(genIDs, id) }
+                    toolNames[id] = p.Name
+                    toolCalls = append(toolCalls, OpenAIToolCall{ ID: id, Type: "function", Function: OpenAIToolCallFunction{Name: p.Name, Arguments: args} })
                 }
             }
             msg := OpenAIMessage{Role: "assistant"}
-            if len(textBuf) > 0 { msg.Content = strings.Join(textBuf, "\n\n") }
+            sep := "\n\n"
+            // A trailing text-only assistant turn is a prefill: the reply continues it
+            // verbatim, so its blocks are joined without adding separators.
+            if mi == len(req.Messages)-1 && len(toolCalls) == 0 { sep = "" }
+            if len(textBuf) > 0 { msg.Content = strings.Join(textBuf, sep) }
             if len(toolCalls) > 0 { msg.ToolCalls = toolCalls }
             out = append(out, msg)
         default:
@@ -256,57 +547,170 @@ func ConvertMessagesToOpenAI(req AnthropicMessageRequest) ([]OpenAIMessage, erro
     return out, nil
 }
 
+// imageOnlyParts converts tool_result content made up solely of Anthropic image
+// blocks into OpenAI image_url parts. It returns nil for any other content.
+func imageOnlyParts(content interface{}) []interface{} {
+    arr, ok := content.([]interface{})
+    if !ok || len(arr) == 0 { return nil }
+    out := make([]interface{}, 0, len(arr))
+    for _, it := range arr {
+        mp, ok := it.(map[string]interface{})
+        if !ok || mp["type"] != "image" { return nil }
+        src, _ := mp["source"].(map[string]interface{})
+        url := ""
+        switch src["type"] {
+        case "base64":
+            url = fmt.Sprintf("data:%v;base64,%v", src["media_type"], src["data"])
+        case "url":
+            url, _ = src["url"].(string)
+        }
+        if url == "" { return nil }
+        out = append(out, map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": url}})
+    }
+    return out
+}
+
 // AnthropicToOpenAI builds a full OpenAIChatRequest from an AnthropicMessageRequest.
-func AnthropicToOpenAI(areq AnthropicMessageRequest) (OpenAIChatRequest, error) {
+func AnthropicToOpenAI(areq AnthropicMessageRequest, opts ...Options) (OpenAIChatRequest, error) {
+    o := pickOptions(opts)
     msgs, err := ConvertMessagesToOpenAI(areq)
     if err != nil { return OpenAIChatRequest{}, err }
+    if o.TrimSystem && len(msgs) > 0 && msgs[0].Role == "system" {
+        if sys, ok := msgs[0].Content.(string); ok { msgs[0].Content = strings.TrimSpace(sys) }
+    }
+    maxStops := o.MaxStopSequences
+    if maxStops <= 0 { maxStops = OpenAIMaxStopSequences }
+    if areq.TopK != nil { o.warn("unsupported_param", "top_k=%d dropped: OpenAI has no equivalent", *areq.TopK) }
     return OpenAIChatRequest{
-        Model:       areq.Model, // model mapping handled by caller if needed
-        Messages:    msgs,
-        Tools:       mapToolsToOpenAI(areq.Tools),
-        Temperature: areq.Temperature,
-        MaxTokens:   areq.MaxTokens,
-        Stop:        areq.StopSequences,
-        Stream:      areq.Stream,
+        Model:             areq.Model, // model mapping handled by caller if needed
+        Messages:          msgs,
+        Tools:             o.mapToolsToOpenAI(areq.Tools),
+        Temperature:       o.convertTemperature(areq.Temperature, anthropicMaxTemperature, openAIMaxTemperature),
+        TopP:              areq.TopP,
+        Seed:              areq.Seed,
+        ParallelToolCalls: parallelToolCalls(areq.ToolChoice),
+        MaxTokens:         areq.MaxTokens,
+        Stop:              o.capStops(areq.StopSequences, maxStops),
+        Stream:            areq.Stream,
+        Metadata:          o.flattenMetadata(areq.Metadata),
+        Extra:             o.forwardExtra(areq.Extra, anthropicOnlyParams, "OpenAI"),
     }, nil
 }
 
 // ============ Reverse direction (OpenAI request -> Anthropic request) ============
 
-func mapToolsToAnthropic(tools []OpenAITool) []AnthropicTool {
-    if len(tools) == 0 { return nil }
-    out := make([]AnthropicTool, 0, len(tools))
-    for _, t := range tools {
-        if strings.ToLower(t.Type) != "function" { continue }
+// mapToolsToAnthropic converts tools plus any legacy functions. Clients migrating
+// between the two may send both; tools win and functions only add names tools lack.
+func mapToolsToAnthropic(tools []OpenAITool, functions []OpenAIFunction) []AnthropicTool {
+    if len(tools) == 0 && len(functions) == 0 { return nil }
+    out := make([]AnthropicTool, 0, len(tools)+len(functions))
+    seen := map[string]bool{}
+    add := func(f OpenAIFunction, cc *AnthropicCacheControl) {
+        if seen[f.Name] { return }
+        seen[f.Name] = true
         out = append(out, AnthropicTool{
-            Name:        t.Function.Name,
-            Description: t.Function.Description,
-            InputSchema: t.Function.Parameters,
+            Name:         f.Name,
+            Description:  f.Description,
+            InputSchema:  f.Parameters,
+            CacheControl: cc,
         })
     }
+    for _, t := range tools {
+        if strings.ToLower(t.Type) != "function" { continue }
+        add(t.Function, t.CacheControl)
+    }
+    for _, f := range functions { add(f, nil) }
     return out
 }
 
+// toolChoiceForParallel maps parallel_tool_calls:false to Anthropic's
+// disable_parallel_tool_use; true and unset are Anthropic's default.
+func toolChoiceForParallel(parallel *bool) *AnthropicToolChoice {
+    if parallel == nil || *parallel { return nil }
+    return &AnthropicToolChoice{Type: "auto", DisableParallelToolUse: true}
+}
+
+// toolChoiceForRequest combines tool_choice, or the legacy function_call when
+// tool_choice is unset, with parallel_tool_calls.
+func (o Options) toolChoiceForRequest(toolChoice, functionCall json.RawMessage, parallel *bool) *AnthropicToolChoice {
+    tc := toolChoiceForParallel(parallel)
+    var mode string
+    if len(toolChoice) > 0 {
+        var named struct{ Function struct{ Name string `json:"name"` } `json:"function"` }
+        switch {
+        case json.Unmarshal(toolChoice, &mode) == nil:
+            switch mode {
+            case "none": return &AnthropicToolChoice{Type: "none"}
+            case "required": return &AnthropicToolChoice{Type: "any", DisableParallelToolUse: tc != nil}
+            case "auto": return tc
+            }
+        case json.Unmarshal(toolChoice, &named) == nil && named.Function.Name != "":
+            return &AnthropicToolChoice{Type: "tool", Name: named.Function.Name, DisableParallelToolUse: tc != nil}
+        }
+        o.warn("unsupported_param", "tool_choice %s not understood; using auto", string(toolChoice))
+        return tc
+    }
+    var named struct{ Name string `json:"name"` }
+    if json.Unmarshal(functionCall, &mode) == nil {
+        if mode == "none" { return &AnthropicToolChoice{Type: "none"} }
+    } else if json.Unmarshal(functionCall, &named) == nil && named.Name != "" {
+        return &AnthropicToolChoice{Type: "tool", Name: named.Name, DisableParallelToolUse: tc != nil}
+    }
+    return tc
+}
+
+// parallelToolCalls is the reverse of toolChoiceForParallel.
+func parallelToolCalls(tc *AnthropicToolChoice) *bool {
+    if tc == nil || !tc.DisableParallelToolUse { return nil }
+    off := false
+    return &off
+}
+
+// refusalPrefix marks an OpenAI refusal replayed to Anthropic as plain text.
+const refusalPrefix = "[refusal] "
+
+// textFromPart extracts text from an OpenAI content part. Text-like variants
+// (output_text, input_text) are accepted; known is false for other types.
+func textFromPart(mp map[string]interface{}) (text string, known bool) {
+    switch mp["type"] {
+    case "text", "output_text", "input_text":
+        ts, _ := mp["text"].(string)
+        return ts, true
+    }
+    return "", false
+}
+
+// takeGeneratedID removes a pending generated id for an id-less tool message:
+// the oldest one whose tool is called name, or the oldest one.
+func takeGeneratedID(pending []string, names map[string]string, name string) (string, []string) {
+    i := 0
+    for j, id := range pending {
+        if name != "" && names[id] == name { i = j; break }
+    }
+    id := pending[i]
+    return id, append(pending[:i:i], pending[i+1:]...)
+}
+
 // OpenAIToAnthropicRequest converts an OpenAI Chat request to Anthropic Messages request.
-func OpenAIToAnthropicRequest(oreq OpenAIChatRequest) (AnthropicMessageRequest, error) {
-    var systemStr string
+func OpenAIToAnthropicRequest(oreq OpenAIChatRequest, opts ...Options) (AnthropicMessageRequest, error) {
+    o := pickOptions(opts)
+    var systemBlocks []AnthropicContent // one text block per system message or part
     var msgs []AnthropicMsg
-    for _, m := range oreq.Messages {
+    var genIDs []string // generated tool_call ids still waiting for an id-less tool message
+    genNames := map[string]string{} // generated id -> tool name, matched against the tool message's name
+    for mi, m := range oreq.Messages {
         switch m.Role {
-        case "system":
-            if systemStr == "" {
-                if s, ok := m.Content.(string); ok {
-                    systemStr = s
-                } else if arr, ok := m.Content.([]interface{}); ok {
-                    var buf []string
-                    for _, it := range arr {
-                        if mp, ok := it.(map[string]interface{}); ok {
-                            if mp["type"] == "text" {
-                                if ts, ok := mp["text"].(string); ok && strings.TrimSpace(ts) != "" { buf = append(buf, ts) }
-                            }
+        case "system", "developer":
+            // developer is the newer name for system; every such message is folded into the system prompt
+            if s, ok := m.Content.(string); ok {
+                if strings.TrimSpace(s) != "" { systemBlocks = append(systemBlocks, AnthropicContent{Type: "text", Text: s}) }
+            } else if arr, ok := m.Content.([]interface{}); ok {
+                for _, it := range arr {
+                    if mp, ok := it.(map[string]interface{}); ok {
+                        if mp["type"] == "text" {
+                            if ts, ok := mp["text"].(string); ok && strings.TrimSpace(ts) != "" { systemBlocks = append(systemBlocks, AnthropicContent{Type: "text", Text: ts, CacheControl: cacheControlOf(mp)}) }
                         }
                     }
-                    if len(buf) > 0 { systemStr = strings.Join(buf, "\n\n") }
                 }
             }
         case "user":
@@ -319,7 +723,7 @@ func OpenAIToAnthropicRequest(oreq OpenAIChatRequest) (AnthropicMessageRequest,
                 for _, it := range arr {
                     if mp, ok := it.(map[string]interface{}); ok {
                         if mp["type"] == "text" {
-                            if ts, ok := mp["text"].(string); ok && strings.TrimSpace(ts) != "" { parts = append(parts, AnthropicContent{Type:"text", Text: ts}) }
+                            if ts, ok := mp["text"].(string); ok && strings.TrimSpace(ts) != "" { parts = append(parts, AnthropicContent{Type:"text", Text: ts, CacheControl: cacheControlOf(mp)}) }
                         }
                     }
                 }
@@ -327,23 +731,55 @@ func OpenAIToAnthropicRequest(oreq OpenAIChatRequest) (AnthropicMessageRequest,
             }
         case "assistant":
             var parts []AnthropicContent
+            // thinking must lead the turn, and must carry its signature to be accepted back
+            if m.ReasoningContent != "" { parts = append(parts, AnthropicContent{Type: "thinking", Thinking: m.ReasoningContent, Signature: m.ReasoningSignature}) }
+            if strings.TrimSpace(m.Refusal) != "" { parts = append(parts, AnthropicContent{Type: "text", Text: refusalPrefix + m.Refusal}) }
             if s, ok := m.Content.(string); ok && strings.TrimSpace(s) != "" { parts = append(parts, AnthropicContent{Type: "text", Text: s}) }
             if arr, ok := m.Content.([]interface{}); ok {
                 for _, it := range arr {
                     if mp, ok := it.(map[string]interface{}); ok {
-                        if mp["type"] == "text" {
-                            if ts, ok := mp["text"].(string); ok && strings.TrimSpace(ts) != "" { parts = append(parts, AnthropicContent{Type:"text", Text: ts}) }
+                        if mp["type"] == "refusal" {
+                            // Anthropic has no refusal block: keep it as marked text so the model sees it declined
+                            if rs, _ := mp["refusal"].(string); strings.TrimSpace(rs) != "" { parts = append(parts, AnthropicContent{Type: "text", Text: refusalPrefix + rs}) }
+                            continue
                         }
+                        ts, known := textFromPart(mp)
+                        if !known { o.warn("unknown_content_part", "assistant content part type %v dropped", mp["type"]); continue }
+                        if strings.TrimSpace(ts) != "" { parts = append(parts, AnthropicContent{Type:"text", Text: ts, CacheControl: cacheControlOf(mp)}) }
                     }
                 }
             }
-            for _, tc := range m.ToolCalls {
+            for ci, tc := range m.ToolCalls {
                 var inRaw json.RawMessage
                 if tc.Function.Arguments != "" { inRaw = json.RawMessage([]byte(tc.Function.Arguments)) }
-                parts = append(parts, AnthropicContent{Type: "tool_use", ID: tc.ID, Name: tc.Function.Name, Input: &inRaw})
+                id := tc.ID
+                if id == "" {
+                    id = generatedToolID(mi, ci, tc.Function.Name, tc.Function.Arguments)
+                    genIDs, genNames[id] = append(genIDs, id), tc.Function.Name
+                    o.warn("tool_call_id_generated", "assistant tool call %q had no id; using %s", tc.Function.Name, id)
+                }
+                parts = append(parts, AnthropicContent{Type: "tool_use", ID: id, Name: tc.Function.Name, Input: &inRaw})
             }
-            if len(parts) > 0 { raw, _ := json.Marshal(parts); msgs = append(msgs, AnthropicMsg{Role: "assistant", Content: raw}) }
-        case "tool":
+            if fc := m.FunctionCall; fc != nil && len(m.ToolCalls) == 0 {
+                // legacy calls carry no id: the role=function reply picks this one up
+                var inRaw json.RawMessage
+                if fc.Arguments != "" { inRaw = json.RawMessage(fc.Arguments) }
+                id := generatedToolID(mi, 0, fc.Name, fc.Arguments)
+                genIDs, genNames[id] = append(genIDs, id), fc.Name
+                parts = append(parts, AnthropicContent{Type: "tool_use", ID: id, Name: fc.Name, Input: &inRaw})
+            }
+            if len(parts) == 0 {
+                // a trailing empty turn is an empty prefill: nothing to continue from
+                if o.EmptyAssistantText == "" || mi == len(oreq.Messages)-1 {
+                    o.warn("empty_assistant_turn", "assistant message %d has no content or tool calls; dropped", mi)
+                    continue
+                }
+                o.warn("empty_assistant_turn", "assistant message %d has no content or tool calls; sent as %q", mi, o.EmptyAssistantText)
+                parts = append(parts, AnthropicContent{Type: "text", Text: o.EmptyAssistantText})
+            }
+            raw, _ := json.Marshal(parts)
+            msgs = append(msgs, AnthropicMsg{Role: "assistant", Content: raw})
+        case "tool", "function":
             var contentStr string
             switch v := m.Content.(type) {
             case string:
@@ -354,55 +790,154 @@ func OpenAIToAnthropicRequest(oreq OpenAIChatRequest) (AnthropicMessageRequest,
                 b, _ := json.Marshal(v)
                 contentStr = string(b)
             }
-            parts := []AnthropicContent{{Type: "tool_result", ToolUseID: m.ToolCallID, Content: contentStr}}
+            toolUseID := m.ToolCallID
+            if toolUseID == "" && len(genIDs) > 0 { toolUseID, genIDs = takeGeneratedID(genIDs, genNames, m.Name) }
+            parts := []AnthropicContent{{Type: "tool_result", ToolUseID: toolUseID, Content: contentStr}}
             raw, _ := json.Marshal(parts)
             msgs = append(msgs, AnthropicMsg{Role: "user", Content: raw})
         }
     }
-    var sysRaw json.RawMessage
-    if systemStr != "" { sysRaw = json.RawMessage([]byte(strconvQuote(systemStr))) }
+    if len(msgs) > 0 && msgs[0].Role != "user" {
+        // Anthropic requires the first turn to be the user's (assistant prefill, lone tool result, ...)
+        text := o.PlaceholderUserText
+        if text == "" { text = "." }
+        raw, _ := json.Marshal([]AnthropicContent{{Type: "text", Text: text}})
+        msgs = append([]AnthropicMsg{{Role: "user", Content: raw}}, msgs...)
+        o.warn("placeholder_user_message", "conversation started with %s; prepended a placeholder user message", msgs[1].Role)
+    }
+    msgs = mergeAdjacentRoles(msgs)
+    if oreq.PresencePenalty != nil { o.warn("unsupported_param", "presence_penalty=%g dropped: Anthropic has no equivalent", *oreq.PresencePenalty) }
+    if oreq.Seed != nil { o.warn("unsupported_param", "seed=%d dropped: Anthropic has no equivalent", *oreq.Seed) }
+    if oreq.N != nil && *oreq.N > 1 { o.warn("unsupported_param", "n=%d dropped: Anthropic returns a single choice", *oreq.N) }
+    if oreq.FrequencyPenalty != nil { o.warn("unsupported_param", "frequency_penalty=%g dropped: Anthropic has no equivalent", *oreq.FrequencyPenalty) }
+    if note := o.jsonModeInstruction(oreq.ResponseFormat); note != "" { systemBlocks = append(systemBlocks, AnthropicContent{Type: "text", Text: note}) }
+    maxTokens := oreq.MaxTokens
+    if maxTokens == 0 { maxTokens = oreq.MaxCompletionTokens }
+    toolChoice := o.toolChoiceForRequest(oreq.ToolChoice, oreq.FunctionCall, oreq.ParallelToolCalls)
+    if len(oreq.Functions) > 0 && len(oreq.Tools) == 0 {
+        // a functions client reads a single function_call, so ask for one call at most
+        if toolChoice == nil { toolChoice = &AnthropicToolChoice{Type: "auto"} }
+        if toolChoice.Type != "none" { toolChoice.DisableParallelToolUse = true }
+    }
     return AnthropicMessageRequest{
         Model:         oreq.Model,
-        System:        sysRaw,
+        System:        o.anthropicSystem(systemBlocks),
         Messages:      msgs,
-        Tools:         mapToolsToAnthropic(oreq.Tools),
-        MaxTokens:     oreq.MaxTokens,
-        Temperature:   oreq.Temperature,
-        StopSequences: oreq.Stop,
+        Tools:         mapToolsToAnthropic(oreq.Tools, oreq.Functions),
+        ToolChoice:    toolChoice,
+        MaxTokens:     maxTokens,
+        Temperature:   o.convertTemperature(oreq.Temperature, openAIMaxTemperature, anthropicMaxTemperature),
+        TopP:          oreq.TopP,
+        StopSequences: o.capStops(o.dropBlankStops(oreq.Stop), o.MaxStopSequences),
         Stream:        oreq.Stream,
+        Metadata:      o.anthropicMetadata(oreq.Metadata, oreq.User),
+        Extra:         o.forwardExtra(oreq.Extra, openAIOnlyParams, "Anthropic"),
     }, nil
 }
 
+// mergeAdjacentRoles folds consecutive messages with the same role into one,
+// concatenating their content blocks in order; Anthropic rejects back-to-back
+// user (or assistant) turns, which tool messages readily produce.
+func mergeAdjacentRoles(msgs []AnthropicMsg) []AnthropicMsg {
+    var out []AnthropicMsg
+    for _, m := range msgs {
+        if n := len(out); n > 0 && out[n-1].Role == m.Role {
+            var prev, cur []json.RawMessage
+            if json.Unmarshal(out[n-1].Content, &prev) == nil && json.Unmarshal(m.Content, &cur) == nil {
+                out[n-1].Content, _ = json.Marshal(append(prev, cur...))
+                continue
+            }
+        }
+        out = append(out, m)
+    }
+    return out
+}
+
+// anthropicSystem renders the collected system blocks as one string, or as a
+// block list when any of them carries a cache_control marker, which only the
+// block form can hold.
+func (o Options) anthropicSystem(blocks []AnthropicContent) json.RawMessage {
+    if len(blocks) == 0 { return nil }
+    if o.TrimSystem {
+        blocks[0].Text = strings.TrimLeftFunc(blocks[0].Text, unicode.IsSpace)
+        last := len(blocks) - 1
+        blocks[last].Text = strings.TrimRightFunc(blocks[last].Text, unicode.IsSpace)
+    }
+    texts := make([]string, len(blocks))
+    cached := false
+    for i, b := range blocks {
+        texts[i] = b.Text
+        if b.CacheControl != nil { cached = true }
+    }
+    if cached { raw, _ := json.Marshal(blocks); return raw }
+    text := strings.Join(texts, "\n\n")
+    if text == "" { return nil }
+    return json.RawMessage(strconvQuote(text))
+}
+
+// cacheControlOf reads the cache_control marker of an OpenAI content part, if any.
+func cacheControlOf(mp map[string]interface{}) *AnthropicCacheControl {
+    v, ok := mp["cache_control"]
+    if !ok { return nil }
+    b, _ := json.Marshal(v)
+    var cc AnthropicCacheControl
+    if json.Unmarshal(b, &cc) != nil || cc.Type == "" { return nil }
+    return &cc
+}
+
 func strconvQuote(s string) string { b, _ := json.Marshal(s); return string(b) }
 
 // AnthropicToOpenAIResponse converts Anthropic non-streaming response to OpenAI format.
-func AnthropicToOpenAIResponse(a AnthropicMessageResponse, openaiModel string) (OpenAIChatResponse, error) {
-    var contentStr string
+func AnthropicToOpenAIResponse(a AnthropicMessageResponse, openaiModel string, opts ...Options) (OpenAIChatResponse, error) {
+    o := pickOptions(opts)
+    var texts []string
     var toolCalls []OpenAIToolCall
+    var reasoning []string
+    var signature string
     for _, c := range a.Content {
         if t, ok := c["type"].(string); ok {
             switch t {
+            case "thinking":
+                // several blocks are joined; only the last signature survives
+                if s, _ := c["thinking"].(string); s != "" { reasoning = append(reasoning, s) }
+                if s, _ := c["signature"].(string); s != "" { signature = s }
             case "text":
-                if s, ok := c["text"].(string); ok {
-                    if contentStr == "" { contentStr = s } else { contentStr += "\n\n" + s }
-                }
+                if s, ok := c["text"].(string); ok && s != "" { texts = append(texts, s) }
+            case "document":
+                // OpenAI has no document part in responses; relay it as text
+                if s := documentSummary(c); s != "" { texts = append(texts, s) }
             case "tool_use":
+                if !o.toolCallAllowed(len(toolCalls)) {
+                    if o.FailOnMaxToolCalls { return OpenAIChatResponse{}, ErrTooManyToolCalls }
+                    continue
+                }
                 name, _ := c["name"].(string)
                 id, _ := c["id"].(string)
                 args := "{}"
                 if in, ok := c["input"]; ok && in != nil {
-                    b, _ := json.Marshal(in)
+                    b, _ := json.Marshal(o.normalizePaths(in))
                     if len(b) > 0 { args = string(b) }
                 }
                 toolCalls = append(toolCalls, OpenAIToolCall{ID: id, Type: "function", Function: OpenAIToolCallFunction{Name: name, Arguments: args}})
             }
         }
     }
-    msg := OpenAIMessage{Role: "assistant"}
-    if contentStr != "" { msg.Content = contentStr }
+    msg := OpenAIMessage{Role: "assistant", ReasoningContent: strings.Join(reasoning, "\n\n"), ReasoningSignature: signature}
+    if o.StructuredContent && len(texts) > 0 {
+        parts := make([]map[string]interface{}, 0, len(texts))
+        for _, t := range texts { parts = append(parts, map[string]interface{}{"type": "text", "text": t}) }
+        msg.Content = parts
+    } else if len(texts) > 0 {
+        msg.Content = strings.Join(texts, "\n\n")
+    }
     if len(toolCalls) > 0 { msg.ToolCalls = toolCalls }
     finish := "stop"
-    if a.StopReason != nil && *a.StopReason == "tool_use" { finish = "tool_calls" }
+    if a.StopReason != nil { finish = openAIFinishReason(*a.StopReason) }
+    if o.LegacyFunctions && len(toolCalls) > 0 {
+        if len(toolCalls) > 1 { o.warn("function_calls_dropped", "%d tool calls after the first dropped: function_call holds one", len(toolCalls)-1) }
+        msg.FunctionCall, msg.ToolCalls = &toolCalls[0].Function, nil
+        finish = "function_call"
+    }
     return OpenAIChatResponse{
         ID:     a.ID,
         Object: "chat.completion",
@@ -416,149 +951,450 @@ func AnthropicToOpenAIResponse(a AnthropicMessageResponse, openaiModel string) (
     }, nil
 }
 
+// documentSummary renders an Anthropic document block as text: a header with the
+// title (or source kind) followed by the document text when it is inline.
+func documentSummary(c map[string]interface{}) string {
+    src, _ := c["source"].(map[string]interface{})
+    label, _ := c["title"].(string)
+    var body string
+    switch src["type"] {
+    case "text":
+        body, _ = src["data"].(string)
+    case "content":
+        if arr, ok := src["content"].([]interface{}); ok {
+            var buf []string
+            for _, it := range arr {
+                if mp, ok := it.(map[string]interface{}); ok && mp["type"] == "text" {
+                    if ts, _ := mp["text"].(string); ts != "" { buf = append(buf, ts) }
+                }
+            }
+            body = strings.Join(buf, "\n\n")
+        }
+    case "url":
+        if u, _ := src["url"].(string); label == "" { label = u }
+    case "base64":
+        if mt, _ := src["media_type"].(string); label == "" { label = mt }
+    }
+    if label == "" { label = "untitled" }
+    if body == "" { return "[document: " + label + "]" }
+    return "[document: " + label + "]\n" + body
+}
+
 // OpenAIToAnthropic maps a non-streaming OpenAI response to Anthropic message.
-func OpenAIToAnthropic(oresp OpenAIChatResponse, requestedModel string) (AnthropicMessageResponse, error) {
-    return mapOpenAIToAnthropic(oresp, requestedModel)
+func OpenAIToAnthropic(oresp OpenAIChatResponse, requestedModel string, opts ...Options) (AnthropicMessageResponse, error) {
+    return mapOpenAIToAnthropic(oresp, requestedModel, pickOptions(opts))
 }
 
-func mapOpenAIToAnthropic(oresp OpenAIChatResponse, requestedModel string) (AnthropicMessageResponse, error) {
+func mapOpenAIToAnthropic(oresp OpenAIChatResponse, requestedModel string, o Options) (AnthropicMessageResponse, error) {
     if len(oresp.Choices) == 0 { return AnthropicMessageResponse{}, fmt.Errorf("no choices") }
     choice := oresp.Choices[0]
     content := make([]map[string]interface{}, 0, 2)
+    if r := choice.Message.ReasoningContent; r != "" {
+        content = append(content, map[string]interface{}{"type": "thinking", "thinking": r, "signature": choice.Message.ReasoningSignature})
+    }
+    if r := choice.Message.Refusal; strings.TrimSpace(r) != "" {
+        content = append(content, map[string]interface{}{"type": "text", "text": r})
+    }
+    var toolBlocks []map[string]interface{}
+    toolByID := map[string]int{}
+    for i, tc := range choice.Message.ToolCalls {
+        if !o.toolCallAllowed(i) {
+            if o.FailOnMaxToolCalls { return AnthropicMessageResponse{}, ErrTooManyToolCalls }
+            break
+        }
+        var argsObj interface{}
+        if json.Valid([]byte(tc.Function.Arguments)) {
+            if err := json.Unmarshal([]byte(tc.Function.Arguments), &argsObj); err != nil { argsObj = map[string]interface{}{"_": tc.Function.Arguments} }
+        } else {
+            argsObj = map[string]interface{}{"_": tc.Function.Arguments}
+        }
+        if tc.ID != "" { toolByID[tc.ID] = len(toolBlocks) }
+        toolBlocks = append(toolBlocks, map[string]interface{}{"type": "tool_use", "id": tc.ID, "name": tc.Function.Name, "input": o.normalizePaths(argsObj)})
+    }
+    placed := make([]bool, len(toolBlocks))
     if s, ok := choice.Message.Content.(string); ok && s != "" {
         content = append(content, map[string]interface{}{"type": "text", "text": s})
     } else if arr, ok := choice.Message.Content.([]interface{}); ok {
+        // Array content may mark where a tool call was made ({"type":"tool_call","id":...});
+        // the call's tool_use block goes there, so text before and after it stays in order.
         var buf []string
+        flush := func() {
+            if len(buf) > 0 { content = append(content, map[string]interface{}{"type":"text","text": strings.Join(buf, "\n\n")}) }
+            buf = nil
+        }
         for _, it := range arr {
             if mp, ok := it.(map[string]interface{}); ok {
-                if mp["type"] == "text" {
+                switch mp["type"] {
+                case "text":
                     if ts, ok := mp["text"].(string); ok && strings.TrimSpace(ts) != "" { buf = append(buf, ts) }
+                case "tool_call", "tool_use":
+                    id, _ := mp["id"].(string)
+                    if i, ok := toolByID[id]; ok && !placed[i] {
+                        flush()
+                        content = append(content, toolBlocks[i])
+                        placed[i] = true
+                    }
                 }
             }
         }
-        if len(buf) > 0 { content = append(content, map[string]interface{}{"type":"text","text": strings.Join(buf, "\n\n")}) }
+        flush()
     }
-    for _, tc := range choice.Message.ToolCalls {
-        var argsObj interface{}
-        if json.Valid([]byte(tc.Function.Arguments)) {
-            if err := json.Unmarshal([]byte(tc.Function.Arguments), &argsObj); err != nil { argsObj = map[string]interface{}{"_": tc.Function.Arguments} }
-        } else {
-            argsObj = map[string]interface{}{"_": tc.Function.Arguments}
-        }
-        content = append(content, map[string]interface{}{"type": "tool_use", "id": tc.ID, "name": tc.Function.Name, "input": argsObj})
+    // tool calls without a position follow the text, as OpenAI sends them
+    for i, b := range toolBlocks {
+        if !placed[i] { content = append(content, b) }
     }
     var stopReason *string
     if choice.FinishReason != "" {
-        sr := choice.FinishReason
+        sr := anthropicStopReason(choice.FinishReason)
         if len(choice.Message.ToolCalls) > 0 { sr = "tool_use" }
         stopReason = &sr
     }
+    if strings.TrimSpace(choice.Message.Refusal) != "" && len(choice.Message.ToolCalls) == 0 { sr := "refusal"; stopReason = &sr }
     var usage *AnthropicUsage
     if oresp.Usage != nil { usage = &AnthropicUsage{InputTokens: oresp.Usage.PromptTokens, OutputTokens: oresp.Usage.CompletionTokens} }
+    // OpenAI reports a stop-sequence match as a plain "stop" without saying which
+    // one matched, so stop_sequence stays null (end_turn), as Anthropic sends it.
     return AnthropicMessageResponse{ ID: fmt.Sprintf("msg_%d", time.Now().UnixNano()), Type: "message", Role: "assistant", Model: requestedModel, Content: content, StopReason: stopReason, StopSequence: nil, Usage: usage }, nil
 }
 
 // ============ Streaming conversions ============
 
+// StreamError is an error reported by the upstream in the middle of a stream.
+// Type uses the Anthropic error vocabulary (overloaded_error, api_error, ...).
+type StreamError struct {
+    Type    string
+    Message string
+}
+
+func (e *StreamError) Error() string { return "upstream stream error: " + e.Type + ": " + e.Message }
+
+// openAIStreamError decodes an OpenAI `{"error":{...}}` stream payload, or returns nil.
+func openAIStreamError(payload string) *StreamError {
+    if !strings.Contains(payload, `"error"`) { return nil }
+    var obj struct { Error *struct { Message string `json:"message"`; Type string `json:"type"`; Code interface{} `json:"code"` } `json:"error"` }
+    if err := json.Unmarshal([]byte(payload), &obj); err != nil || obj.Error == nil { return nil }
+    return &StreamError{Type: AnthropicErrorType(obj.Error.Type, fmt.Sprint(obj.Error.Code)), Message: obj.Error.Message}
+}
+
+// AnthropicErrorType maps an OpenAI error type/code to the closest Anthropic error type.
+func AnthropicErrorType(openaiType, code string) string {
+    switch {
+    case strings.Contains(openaiType, "rate_limit") || strings.Contains(code, "rate_limit"):
+        return "rate_limit_error"
+    case openaiType == "invalid_request_error":
+        return "invalid_request_error"
+    case strings.Contains(openaiType, "authentication") || code == "invalid_api_key":
+        return "authentication_error"
+    case strings.Contains(openaiType, "overloaded") || strings.Contains(code, "overloaded"):
+        return "overloaded_error"
+    }
+    return "api_error"
+}
+
+// OpenAIErrorType maps an Anthropic error type to the OpenAI error type vocabulary.
+func OpenAIErrorType(anthropicType string) string {
+    switch anthropicType {
+    case "invalid_request_error", "not_found_error", "request_too_large":
+        return "invalid_request_error"
+    case "authentication_error", "permission_error":
+        return "authentication_error"
+    case "rate_limit_error":
+        return "rate_limit_error"
+    }
+    return "server_error"
+}
+
+// openAIFinishReason maps an Anthropic stop_reason onto the OpenAI finish_reason vocabulary.
+func openAIFinishReason(stop string) string {
+    switch stop {
+    case "max_tokens": return "length"
+    case "end_turn", "stop_sequence": return "stop"
+    case "tool_use": return "tool_calls"
+    case "refusal": return "content_filter"
+    }
+    return "stop"
+}
+
+// anthropicStopReason maps an OpenAI finish_reason onto the Anthropic stop_reason
+// vocabulary. Values Anthropic clients wouldn't recognize become end_turn.
+func anthropicStopReason(finish string) string {
+    switch finish {
+    case "length": return "max_tokens"
+    case "tool_calls", "function_call": return "tool_use"
+    case "content_filter": return "refusal"
+    }
+    return "end_turn"
+}
+
+// ReplayAnthropicResponse emits a complete non-streaming response as the
+// Anthropic SSE event sequence (message_start ... message_stop), for upstreams
+// that answer a stream request with plain JSON.
+func ReplayAnthropicResponse(a AnthropicMessageResponse, enc func(event string, payload interface{})) {
+    usage := map[string]interface{}{"input_tokens": 0, "output_tokens": 0}
+    if a.Usage != nil { usage = map[string]interface{}{"input_tokens": a.Usage.InputTokens, "output_tokens": a.Usage.OutputTokens} }
+    enc("message_start", map[string]interface{}{"type": "message_start", "message": map[string]interface{}{"id": a.ID, "type": "message", "role": "assistant", "model": a.Model, "content": []interface{}{}, "usage": usage}})
+    for i, c := range a.Content {
+        switch c["type"] {
+        case "text":
+            enc("content_block_start", map[string]interface{}{"type": "content_block_start", "index": i, "content_block": map[string]interface{}{"type": "text", "text": ""}})
+            enc("content_block_delta", map[string]interface{}{"type": "content_block_delta", "index": i, "delta": map[string]interface{}{"type": "text_delta", "text": c["text"]}})
+        case "thinking":
+            enc("content_block_start", map[string]interface{}{"type": "content_block_start", "index": i, "content_block": map[string]interface{}{"type": "thinking", "thinking": ""}})
+            enc("content_block_delta", map[string]interface{}{"type": "content_block_delta", "index": i, "delta": map[string]interface{}{"type": "thinking_delta", "thinking": c["thinking"]}})
+            if sig, _ := c["signature"].(string); sig != "" { enc("content_block_delta", map[string]interface{}{"type": "content_block_delta", "index": i, "delta": map[string]interface{}{"type": "signature_delta", "signature": sig}}) }
+        case "tool_use":
+            enc("content_block_start", map[string]interface{}{"type": "content_block_start", "index": i, "content_block": map[string]interface{}{"type": "tool_use", "id": c["id"], "name": c["name"], "input": map[string]interface{}{}}})
+            b, _ := json.Marshal(c["input"])
+            enc("content_block_delta", map[string]interface{}{"type": "content_block_delta", "index": i, "delta": map[string]interface{}{"type": "input_json_delta", "partial_json": string(b)}})
+        default:
+            enc("content_block_start", map[string]interface{}{"type": "content_block_start", "index": i, "content_block": c})
+        }
+        enc("content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": i})
+    }
+    stop := "end_turn"
+    if a.StopReason != nil && *a.StopReason != "" { stop = *a.StopReason }
+    enc("message_delta", map[string]interface{}{"type": "message_delta", "delta": map[string]interface{}{"stop_reason": stop, "stop_sequence": a.StopSequence}, "usage": map[string]interface{}{"output_tokens": usage["output_tokens"]}})
+    enc("message_stop", map[string]interface{}{"type": "message_stop"})
+}
+
 // ConvertOpenAIStreamToAnthropic converts OpenAI SSE chunks to Anthropic-style events via enc callback.
-func ConvertOpenAIStreamToAnthropic(ctx context.Context, requestedModel string, body io.Reader, enc func(event string, payload interface{})) error {
-    enc("message_start", map[string]interface{}{"type": "message_start", "message": map[string]interface{}{"id": fmt.Sprintf("msg_%d", time.Now().UnixNano()), "type": "message", "role": "assistant", "model": requestedModel, "content": []interface{}{}}})
-    sentTextStart := false
+// Content blocks are emitted in sequence as Anthropic does: a block is closed before the next
+// one starts. Tool calls open a tool_use block once their id and name are known and stream each
+// argument fragment as an input_json_delta. A call that becomes ready while another tool block is
+// still open is queued until that block ends, then sends its accumulated arguments as one delta.
+func ConvertOpenAIStreamToAnthropic(ctx context.Context, requestedModel string, body io.Reader, enc func(event string, payload interface{}), opts ...Options) error {
+    o := pickOptions(opts)
+    // message_start waits for the first chunk so the upstream id can be reused.
+    msgStarted := false
+    model := requestedModel
+    startMessage := func(id string) {
+        if msgStarted { return }
+        msgStarted = true
+        if id == "" { id = fmt.Sprintf("msg_%d", time.Now().UnixNano()) }
+        enc("message_start", map[string]interface{}{"type": "message_start", "message": map[string]interface{}{"id": id, "type": "message", "role": "assistant", "model": model, "content": []interface{}{}}})
+    }
     totalText := ""
-    type toolBuf struct{ id, name string; idx int; args string }
-    toolByIdx := map[int]*toolBuf{}
-    reader := bufio.NewReader(body)
+    nextBlock := 0
+    openBlock := -1 // index of the currently open content block, -1 when none
+    textOpen, thinkingOpen := false, false
+    type toolBuf struct{ id, name string; block int; started, dropped, genID, queued bool; args string }
+    toolByIdx := map[int]*toolBuf{} // latest call at each upstream index
+    toolCount := 0
+    var pending *toolBuf // tool seen but not started yet (id or name still missing)
+    var queue []*toolBuf // ready calls waiting for the open tool block to end
+    overLimit := false
+    finish, toolStarted := "", false // last upstream finish_reason; whether a tool_use block was sent
+    closeOpen := func() {
+        if openBlock < 0 { return }
+        enc("content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": openBlock})
+        openBlock, textOpen, thinkingOpen = -1, false, false
+    }
+    argsDelta := func(b *toolBuf, piece string) {
+        enc("content_block_delta", map[string]interface{}{"type": "content_block_delta", "index": b.block, "delta": map[string]interface{}{"type": "input_json_delta", "partial_json": piece}})
+    }
+    startTool := func(b *toolBuf) {
+        closeOpen()
+        b.block, b.started, toolStarted = nextBlock, true, true
+        nextBlock++
+        openBlock = b.block
+        if pending == b { pending = nil }
+        // forced early (another block or the stream end came first) before any id arrived
+        if b.id == "" { b.id, b.genID = generatedToolID(0, b.block, b.name, b.args), true }
+        enc("content_block_start", map[string]interface{}{"type": "content_block_start", "index": b.block, "content_block": map[string]interface{}{"type": "tool_use", "id": b.id, "name": b.name, "input": map[string]interface{}{}}})
+        if b.args != "" { argsDelta(b, b.args) }
+    }
+    // Upstreams may interleave fragments of several indices, so a ready call never
+    // closes a tool block that could still receive arguments.
+    queueOrStart := func(b *toolBuf) {
+        if openBlock < 0 || textOpen || thinkingOpen { startTool(b); return }
+        if pending == b { pending = nil }
+        if !b.queued { b.queued = true; queue = append(queue, b) }
+    }
+    flushQueued := func() {
+        for len(queue) > 0 { b := queue[0]; queue = queue[1:]; startTool(b) }
+    }
+    flushPending := func() { if pending != nil { queueOrStart(pending) } }
+    // Text or reasoning arriving while a call still waits for its name goes first:
+    // starting the call now would send it nameless. A call missing only its id starts.
+    flushNamedPending := func() { if pending != nil && pending.name != "" { startTool(pending) } }
+    continuesOpenTool := func(idx int) bool {
+        b, ok := toolByIdx[idx]
+        return ok && b.started && !textOpen && b.block == openBlock
+    }
+    reader := newSSEReader(body, o.MaxSSELine)
     for {
         select { case <-ctx.Done(): return ctx.Err(); default: }
-        line, err := reader.ReadString('\n')
-        if err != nil { if errors.Is(err, io.EOF) { break }; break }
-        line = strings.TrimSpace(line)
-        if line == "" || !strings.HasPrefix(line, "data: ") { continue }
-        payload := strings.TrimPrefix(line, "data: ")
+        ev, err := reader.next()
+        if errors.Is(err, ErrSSELineTooLong) {
+            enc("error", map[string]interface{}{"type": "error", "error": map[string]interface{}{"type": "api_error", "message": err.Error()}})
+            return err
+        }
+        if err != nil { break }
+        payload := strings.TrimSpace(ev.data)
         if payload == "[DONE]" { break }
+        if se := openAIStreamError(payload); se != nil {
+            enc("error", map[string]interface{}{"type": "error", "error": map[string]interface{}{"type": se.Type, "message": se.Message}})
+            return se
+        }
         var chunk OpenAIStreamChunk
         if err := json.Unmarshal([]byte(payload), &chunk); err != nil { continue }
+        if o.ReportUpstreamModel && chunk.Model != "" { model = chunk.Model }
+        startMessage(chunk.ID)
         if len(chunk.Choices) == 0 { continue }
+        if f := chunk.Choices[0].FinishReason; f != "" { finish = f }
         d := chunk.Choices[0].Delta
-        if d.Content != "" {
-            if !sentTextStart {
-                enc("content_block_start", map[string]interface{}{"type": "content_block_start", "index": 0, "content_block": map[string]interface{}{"type": "text", "text": ""}})
-                sentTextStart = true
+        // reasoning precedes the answer, so it is emitted before text in the same chunk
+        if d.ReasoningContent != "" || (d.ReasoningSignature != "" && thinkingOpen) {
+            if !thinkingOpen {
+                flushQueued()
+                flushNamedPending()
+                closeOpen()
+                openBlock, thinkingOpen = nextBlock, true
+                nextBlock++
+                enc("content_block_start", map[string]interface{}{"type": "content_block_start", "index": openBlock, "content_block": map[string]interface{}{"type": "thinking", "thinking": ""}})
+            }
+            if d.ReasoningContent != "" { enc("content_block_delta", map[string]interface{}{"type": "content_block_delta", "index": openBlock, "delta": map[string]interface{}{"type": "thinking_delta", "thinking": d.ReasoningContent}}) }
+            if d.ReasoningSignature != "" { enc("content_block_delta", map[string]interface{}{"type": "content_block_delta", "index": openBlock, "delta": map[string]interface{}{"type": "signature_delta", "signature": d.ReasoningSignature}}) }
+        }
+        text := func() {
+            if d.Content != "" {
+                if !textOpen {
+                    flushQueued()
+                    flushNamedPending()
+                    closeOpen()
+                    openBlock, textOpen = nextBlock, true
+                    nextBlock++
+                    enc("content_block_start", map[string]interface{}{"type": "content_block_start", "index": openBlock, "content_block": map[string]interface{}{"type": "text", "text": ""}})
+                }
+                totalText += d.Content
+                enc("content_block_delta", map[string]interface{}{"type": "content_block_delta", "index": openBlock, "delta": map[string]interface{}{"type": "text_delta", "text": d.Content}})
             }
-            totalText += d.Content
-            enc("content_block_delta", map[string]interface{}{"type": "content_block_delta", "index": 0, "delta": map[string]interface{}{"type": "text_delta", "text": d.Content}})
         }
-        if len(d.ToolCalls) > 0 {
+        tools := func() {
             for _, tc := range d.ToolCalls {
                 b, ok := toolByIdx[tc.Index]
-                if !ok { b = &toolBuf{idx: tc.Index}; toolByIdx[tc.Index] = b }
-                if tc.ID != "" { b.id = tc.ID }
-                if tc.Function.Name != "" { b.name = tc.Function.Name }
-                if tc.Function.Arguments != "" { b.args += tc.Function.Arguments }
+                if ok && tc.ID != "" && b.id != "" && !b.genID && tc.ID != b.id {
+                    // malformed upstream: a new id at a used index is a new call, not more of the old one
+                    o.warn("duplicate_tool_index", "tool call %s reuses index %d of %s; kept as a separate call", tc.ID, tc.Index, b.id)
+                    ok = false
+                }
+                if !ok {
+                    b = &toolBuf{}
+                    toolByIdx[tc.Index] = b
+                    toolCount++
+                    if b.dropped = !o.toolCallAllowed(toolCount - 1); b.dropped {
+                        overLimit = true
+                        if o.FailOnMaxToolCalls { return }
+                        continue
+                    }
+                    flushPending()
+                    pending = b
+                }
+                if b.dropped { continue }
+                if !b.started {
+                    if tc.ID != "" { b.id = tc.ID }
+                    if tc.Function.Name != "" { b.name = tc.Function.Name }
+                    b.args += tc.Function.Arguments
+                    if b.id != "" && b.name != "" && !b.queued { queueOrStart(b) }
+                    continue
+                }
+                if tc.Function.Arguments == "" { continue }
+                if openBlock != b.block { o.warn("late_tool_fragment", "arguments for closed tool block %d dropped", b.block); continue }
+                argsDelta(b, tc.Function.Arguments)
             }
         }
-    }
-    if sentTextStart { enc("content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": 0}) }
-    if len(toolByIdx) > 0 {
-        idxs := make([]int, 0, len(toolByIdx))
-        for k := range toolByIdx { idxs = append(idxs, k) }
-        sort.Ints(idxs)
-        for i, idx := range idxs {
-            b := toolByIdx[idx]
-            var inputObj interface{} = map[string]interface{}{}
-            if strings.TrimSpace(b.args) != "" && json.Valid([]byte(b.args)) {
-                var tmp interface{}
-                if err := json.Unmarshal([]byte(b.args), &tmp); err == nil { inputObj = tmp }
-            }
-            enc("content_block_start", map[string]interface{}{"type": "content_block_start", "index": i + 1, "content_block": map[string]interface{}{"type": "tool_use", "id": b.id, "name": b.name, "input": inputObj}})
-            enc("content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": i + 1})
+        // A chunk can carry text and tool fragments together. Fragments that continue
+        // the open tool block go first, so the text doesn't close it under them;
+        // otherwise text comes first, as it does when the upstream splits them.
+        toolFirst := false
+        for _, tc := range d.ToolCalls { toolFirst = toolFirst || continuesOpenTool(tc.Index) }
+        if toolFirst { tools(); text() } else { text(); tools() }
+        if overLimit && o.FailOnMaxToolCalls {
+            enc("error", map[string]interface{}{"type": "error", "error": map[string]interface{}{"type": "api_error", "message": ErrTooManyToolCalls.Error()}})
+            return ErrTooManyToolCalls
         }
     }
-    enc("message_delta", map[string]interface{}{
+    startMessage("")
+    flushQueued()
+    flushPending()
+    flushQueued()
+    closeOpen()
+    // agent loops run tools only on tool_use, whatever finish_reason the upstream sent
+    stop := anthropicStopReason(finish)
+    if toolStarted { stop = "tool_use" }
+    msgDelta := map[string]interface{}{
         "type":  "message_delta",
-        "delta": map[string]interface{}{"stop_reason": "end_turn"},
+        "delta": map[string]interface{}{"stop_reason": stop, "stop_sequence": nil},
         "usage": map[string]int{"input_tokens": 0, "output_tokens": len(totalText) / 4},
-    })
+    }
+    if o.ReportUpstreamModel { msgDelta["model"] = model }
+    enc("message_delta", msgDelta)
     enc("message_stop", map[string]interface{}{"type": "message_stop"})
     return nil
 }
 
 // ConvertAnthropicStreamToOpenAI converts Anthropic SSE events to OpenAI streaming chunks.
-func ConvertAnthropicStreamToOpenAI(ctx context.Context, openaiModel string, body io.Reader, emit func(chunk map[string]interface{})) error {
+// Fields may come in any order within an event; a frame without an event line
+// takes its event from the payload's "type".
+func ConvertAnthropicStreamToOpenAI(ctx context.Context, openaiModel string, body io.Reader, emit func(chunk map[string]interface{}), opts ...Options) error {
+    o := pickOptions(opts)
     roleSent := false
     nextToolIdx := 0
     contentIdxToToolIdx := map[int]int{}
     toolArgsByToolIdx := map[int]string{}
-    reader := bufio.NewReader(body)
+    thinkingBlocks := map[int]bool{} // content indices of thinking/redacted_thinking blocks
+    inputTokens, outputTokens := 0, 0
+    finish := "stop" // replaced by the mapped message_delta stop_reason
+    reader := newSSEReader(body, o.MaxSSELine)
+    // one id per response: SDKs correlate chunks by id
+    chunkID := fmt.Sprintf("chatcmpl_%d", time.Now().UnixNano())
     send := func(delta map[string]interface{}, finishReason string) {
-        ch := map[string]interface{}{"id": fmt.Sprintf("chatcmplchunk_%d", time.Now().UnixNano()), "object": "chat.completion.chunk", "model": openaiModel, "choices": []map[string]interface{}{{"index": 0, "delta": delta}}}
+        ch := map[string]interface{}{"id": chunkID, "object": "chat.completion.chunk", "model": openaiModel, "choices": []map[string]interface{}{{"index": 0, "delta": delta}}}
         if finishReason != "" { ch["choices"].([]map[string]interface{})[0]["finish_reason"] = finishReason }
         emit(ch)
     }
     for {
         select { case <-ctx.Done(): return ctx.Err(); default: }
-        line, err := reader.ReadString('\n')
-        if err != nil { if errors.Is(err, io.EOF) { break }; break }
-        line = strings.TrimSpace(line)
-        if line == "" { continue }
-        if !strings.HasPrefix(line, "event:") { continue }
-        ev := strings.TrimSpace(strings.TrimPrefix(line, "event:"))
-        dataLine, err2 := reader.ReadString('\n')
-        if err2 != nil { break }
-        if !strings.HasPrefix(dataLine, "data:") { continue }
-        payload := strings.TrimSpace(strings.TrimPrefix(dataLine, "data:"))
+        e, err := reader.next()
+        if errors.Is(err, ErrSSELineTooLong) {
+            emit(map[string]interface{}{"error": map[string]interface{}{"message": err.Error(), "type": "server_error", "code": nil}})
+            return err
+        }
+        if err != nil { break }
+        ev, payload := e.eventType(), strings.TrimSpace(e.data)
+        if ev == "" { continue }
         switch ev {
         case "message_start":
+            var obj struct { Message struct { Usage struct { InputTokens int `json:"input_tokens"` } `json:"usage"` } `json:"message"` }
+            if json.Unmarshal([]byte(payload), &obj) == nil { inputTokens = obj.Message.Usage.InputTokens }
             if !roleSent { send(map[string]interface{}{"role": "assistant"}, ""); roleSent = true }
         case "content_block_start":
             var obj struct { Type string `json:"type"`; Index int `json:"index"`; ContentBlock map[string]interface{} `json:"content_block"` }
             if err := json.Unmarshal([]byte(payload), &obj); err != nil { continue }
-            if t, _ := obj.ContentBlock["type"].(string); t == "tool_use" {
+            t, _ := obj.ContentBlock["type"].(string)
+            if t == "thinking" || t == "redacted_thinking" {
+                thinkingBlocks[obj.Index] = true
+                if s, _ := obj.ContentBlock["thinking"].(string); s != "" { send(map[string]interface{}{"reasoning_content": s}, "") }
+                continue
+            }
+            if t == "tool_use" {
+                if !o.toolCallAllowed(nextToolIdx) {
+                    if o.FailOnMaxToolCalls {
+                        emit(map[string]interface{}{"error": map[string]interface{}{"message": ErrTooManyToolCalls.Error(), "type": "server_error", "code": nil}})
+                        return ErrTooManyToolCalls
+                    }
+                    continue
+                }
                 id, _ := obj.ContentBlock["id"].(string)
                 name, _ := obj.ContentBlock["name"].(string)
+                if o.LegacyFunctions && nextToolIdx > 0 {
+                    o.warn("function_calls_dropped", "tool call %s after the first dropped: function_call holds one", id)
+                    continue
+                }
                 toolIdx := nextToolIdx
                 contentIdxToToolIdx[obj.Index] = toolIdx
                 delta := map[string]interface{}{"tool_calls": []map[string]interface{}{{"id": id, "type": "function", "index": toolIdx, "function": map[string]interface{}{"name": name}}}}
+                if o.LegacyFunctions { delta = map[string]interface{}{"function_call": map[string]interface{}{"name": name, "arguments": ""}} }
                 send(delta, "")
                 nextToolIdx++
             }
@@ -566,6 +1402,17 @@ func ConvertAnthropicStreamToOpenAI(ctx context.Context, openaiModel string, bod
             var obj struct { Type string `json:"type"`; Index int `json:"index"`; Delta map[string]interface{} `json:"delta"` }
             if err := json.Unmarshal([]byte(payload), &obj); err != nil { continue }
             if obj.Delta == nil { continue }
+            if thinkingBlocks[obj.Index] || obj.Delta["type"] == "thinking_delta" {
+                // reasoning channel: thinking text never leaks into content
+                if sig, _ := obj.Delta["signature"].(string); obj.Delta["type"] == "signature_delta" {
+                    if sig != "" { send(map[string]interface{}{"reasoning_signature": sig}, "") }
+                    continue
+                }
+                s, _ := obj.Delta["thinking"].(string)
+                if s == "" { s, _ = obj.Delta["text"].(string) }
+                if s != "" { send(map[string]interface{}{"reasoning_content": s}, "") }
+                continue
+            }
             if obj.Delta["type"] == "text_delta" {
                 if s, _ := obj.Delta["text"].(string); s != "" { send(map[string]interface{}{"content": s}, "") }
             } else if obj.Delta["type"] == "input_json_delta" {
@@ -575,12 +1422,28 @@ func ConvertAnthropicStreamToOpenAI(ctx context.Context, openaiModel string, bod
                 if !ok { continue }
                 toolArgsByToolIdx[toolIdx] += piece
                 delta := map[string]interface{}{"tool_calls": []map[string]interface{}{{"index": toolIdx, "type": "function", "function": map[string]interface{}{"arguments": piece}}}}
+                if o.LegacyFunctions { delta = map[string]interface{}{"function_call": map[string]interface{}{"arguments": piece}} }
                 send(delta, "")
             }
         case "message_delta":
-            // ignore for now
+            var obj struct { Delta struct { StopReason string `json:"stop_reason"` } `json:"delta"`; Usage struct { OutputTokens int `json:"output_tokens"` } `json:"usage"` }
+            if json.Unmarshal([]byte(payload), &obj) != nil { continue }
+            if obj.Delta.StopReason != "" { finish = openAIFinishReason(obj.Delta.StopReason) }
+            if o.LegacyFunctions && finish == "tool_calls" { finish = "function_call" }
+            if obj.Usage.OutputTokens > 0 { outputTokens = obj.Usage.OutputTokens }
         case "message_stop":
-            send(map[string]interface{}{}, "stop")
+            send(map[string]interface{}{}, finish)
+            if o.IncludeUsage {
+                emit(map[string]interface{}{"id": chunkID, "object": "chat.completion.chunk", "model": openaiModel, "choices": []map[string]interface{}{},
+                    "usage": map[string]interface{}{"prompt_tokens": inputTokens, "completion_tokens": outputTokens, "total_tokens": inputTokens + outputTokens}})
+            }
+        case "error":
+            var obj struct { Error struct { Type string `json:"type"`; Message string `json:"message"` } `json:"error"` }
+            _ = json.Unmarshal([]byte(payload), &obj)
+            se := &StreamError{Type: obj.Error.Type, Message: obj.Error.Message}
+            if se.Type == "" { se.Type = "api_error" }
+            emit(map[string]interface{}{"error": map[string]interface{}{"message": se.Message, "type": OpenAIErrorType(se.Type), "code": se.Type}})
+            return se
         }
     }
     return nil
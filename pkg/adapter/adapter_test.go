@@ -3,6 +3,9 @@ package adapter_test
 import (
     "context"
     "encoding/json"
+    "errors"
+    "fmt"
+    "reflect"
     "strings"
     "testing"
 
@@ -105,18 +108,19 @@ func TestOpenAIToAnthropicRequest_ToolResultMapping(t *testing.T) {
     oreq := ad.OpenAIChatRequest{
         Model: "gpt-x",
         Messages: []ad.OpenAIMessage{
+            {Role: "user", Content: "read it"},
             {Role: "assistant", ToolCalls: []ad.OpenAIToolCall{{ ID: "call_42", Type: "function", Function: ad.OpenAIToolCallFunction{Name: "Read", Arguments: `{"path":"/tmp/a.png"}`}}}},
             {Role: "tool", ToolCallID: "call_42", Content: "OK"},
         },
     }
     areq, err := ad.OpenAIToAnthropicRequest(oreq)
     if err != nil { t.Fatalf("OpenAIToAnthropicRequest: %v", err) }
-    if len(areq.Messages) != 2 { t.Fatalf("messages len: %d", len(areq.Messages)) }
+    if len(areq.Messages) != 3 { t.Fatalf("messages len: %d", len(areq.Messages)) }
     var parts0 []ad.AnthropicContent
-    if err := json.Unmarshal(areq.Messages[0].Content, &parts0); err != nil { t.Fatalf("parts0: %v", err) }
+    if err := json.Unmarshal(areq.Messages[1].Content, &parts0); err != nil { t.Fatalf("parts0: %v", err) }
     if len(parts0) != 1 || parts0[0].Type != "tool_use" || parts0[0].ID != "call_42" || parts0[0].Name != "Read" { t.Fatalf("assistant tool_use wrong: %#v", parts0) }
     var parts1 []ad.AnthropicContent
-    if err := json.Unmarshal(areq.Messages[1].Content, &parts1); err != nil { t.Fatalf("parts1: %v", err) }
+    if err := json.Unmarshal(areq.Messages[2].Content, &parts1); err != nil { t.Fatalf("parts1: %v", err) }
     if len(parts1) != 1 || parts1[0].Type != "tool_result" || parts1[0].ToolUseID != "call_42" { t.Fatalf("user tool_result wrong: %#v", parts1) }
     if s, ok := parts1[0].Content.(string); !ok || s != "OK" { t.Fatalf("tool_result content: %#v", parts1[0].Content) }
 }
@@ -209,3 +213,1290 @@ func TestConvertAnthropicStreamToOpenAI_InterleavedTwoTools(t *testing.T) {
     }
 }
 
+
+func TestAnthropicToOpenAI_CustomToolType(t *testing.T) {
+    var tools []ad.AnthropicTool
+    if err := json.Unmarshal([]byte(`[
+        {"type":"custom","name":"lookup","description":"Find things","input_schema":{"type":"object","properties":{"q":{"type":"string"}}}},
+        {"name":"plain","input_schema":{"type":"object"}}
+    ]`), &tools); err != nil { t.Fatalf("unmarshal tools: %v", err) }
+    oreq, err := ad.AnthropicToOpenAI(ad.AnthropicMessageRequest{ Tools: tools, Messages: []ad.AnthropicMsg{{Role:"user", Content: json.RawMessage(`"hi"`)}} })
+    if err != nil { t.Fatalf("AnthropicToOpenAI: %v", err) }
+    if len(oreq.Tools) != 2 { t.Fatalf("tools len: %d", len(oreq.Tools)) }
+    ct := oreq.Tools[0]
+    if ct.Type != "function" || ct.Function.Name != "lookup" || ct.Function.Description != "Find things" { t.Fatalf("custom tool wrong: %#v", ct) }
+    if ct.Function.Parameters["type"] != "object" || ct.Function.Parameters["properties"] == nil { t.Fatalf("custom tool schema wrong: %#v", ct.Function.Parameters) }
+    if oreq.Tools[1].Type != "function" || oreq.Tools[1].Function.Name != "plain" { t.Fatalf("untyped tool wrong: %#v", oreq.Tools[1]) }
+}
+
+func TestOpenAIToAnthropicRequest_AssistantOutputTextPart(t *testing.T) {
+    var warned []string
+    oreq := ad.OpenAIChatRequest{
+        Messages: []ad.OpenAIMessage{
+            {Role: "user", Content: "hi"},
+            {Role: "assistant", Content: []interface{}{
+                map[string]interface{}{"type": "output_text", "text": "Captured"},
+                map[string]interface{}{"type": "mystery", "data": "?"},
+            }},
+        },
+    }
+    areq, err := ad.OpenAIToAnthropicRequest(oreq, ad.Options{Warn: func(kind, detail string) { warned = append(warned, kind) }})
+    if err != nil { t.Fatalf("OpenAIToAnthropicRequest: %v", err) }
+    if len(areq.Messages) != 2 { t.Fatalf("messages len: %d", len(areq.Messages)) }
+    var parts []ad.AnthropicContent
+    if err := json.Unmarshal(areq.Messages[1].Content, &parts); err != nil { t.Fatalf("parts: %v", err) }
+    if len(parts) != 1 || parts[0].Type != "text" || parts[0].Text != "Captured" { t.Fatalf("output_text not captured: %#v", parts) }
+    if len(warned) != 1 || warned[0] != "unknown_content_part" { t.Fatalf("expected one unknown part warning, got %v", warned) }
+}
+
+func TestConvertOpenAIStreamToAnthropic_StreamsToolArgsIncrementally(t *testing.T) {
+    s := ""+
+        "data: {\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"id\":\"call_a\",\"type\":\"function\",\"index\":0,\"function\":{\"name\":\"alpha\"}}]}}]}\n\n"+
+        "data: {\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\"{\\\"a\\\":\"}}]}}]}\n\n"+
+        "data: {\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\"1}\"}}]}}]}\n\n"+
+        "data: {\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"id\":\"call_b\",\"type\":\"function\",\"index\":1,\"function\":{\"name\":\"beta\",\"arguments\":\"{}\"}}]}}]}\n\n"+
+        "data: [DONE]\n\n"
+    var seq []string
+    _ = ad.ConvertOpenAIStreamToAnthropic(context.Background(), "claude-x", strings.NewReader(s), func(event string, payload interface{}){
+        m := payload.(map[string]interface{})
+        switch event {
+        case "content_block_start":
+            cb := m["content_block"].(map[string]interface{})
+            seq = append(seq, fmt.Sprintf("start:%v:%v:%v", m["index"], cb["id"], cb["name"]))
+        case "content_block_delta":
+            seq = append(seq, fmt.Sprintf("delta:%v:%v", m["index"], m["delta"].(map[string]interface{})["partial_json"]))
+        case "content_block_stop":
+            seq = append(seq, fmt.Sprintf("stop:%v", m["index"]))
+        }
+    })
+    want := []string{"start:0:call_a:alpha", `delta:0:{"a":`, "delta:0:1}", "stop:0", "start:1:call_b:beta", "delta:1:{}", "stop:1"}
+    if strings.Join(seq, "|") != strings.Join(want, "|") { t.Fatalf("event sequence:\n got %v\nwant %v", seq, want) }
+}
+
+func TestConvertAnthropicStreamToOpenAI_ThinkingBlockRoutesToReasoning(t *testing.T) {
+    s := ""+
+        "event: message_start\n"+
+        "data: {\"type\":\"message_start\",\"message\":{}}\n\n"+
+        "event: content_block_start\n"+
+        "data: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"thinking\",\"thinking\":\"\"}}\n\n"+
+        "event: content_block_delta\n"+
+        "data: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"thinking_delta\",\"thinking\":\"Let me think\"}}\n\n"+
+        "event: content_block_delta\n"+
+        "data: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"signature_delta\",\"signature\":\"sig\"}}\n\n"+
+        "event: content_block_stop\n"+
+        "data: {\"type\":\"content_block_stop\",\"index\":0}\n\n"+
+        "event: content_block_start\n"+
+        "data: {\"type\":\"content_block_start\",\"index\":1,\"content_block\":{\"type\":\"redacted_thinking\",\"data\":\"opaque\"}}\n\n"+
+        "event: content_block_start\n"+
+        "data: {\"type\":\"content_block_start\",\"index\":2,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n"+
+        "event: content_block_delta\n"+
+        "data: {\"type\":\"content_block_delta\",\"index\":2,\"delta\":{\"type\":\"text_delta\",\"text\":\"Answer\"}}\n\n"+
+        "event: message_stop\n"+
+        "data: {\"type\":\"message_stop\"}\n\n"
+    var reasoning, content []string
+    _ = ad.ConvertAnthropicStreamToOpenAI(context.Background(), "gpt-x", strings.NewReader(s), func(m map[string]interface{}){
+        d := m["choices"].([]map[string]interface{})[0]["delta"].(map[string]interface{})
+        if r, ok := d["reasoning_content"].(string); ok { reasoning = append(reasoning, r) }
+        if c, ok := d["content"].(string); ok { content = append(content, c) }
+        if _, ok := d["tool_calls"]; ok { t.Fatalf("unexpected tool_calls: %#v", d) }
+    })
+    if strings.Join(reasoning, "") != "Let me think" { t.Fatalf("reasoning: %q", reasoning) }
+    if strings.Join(content, "") != "Answer" { t.Fatalf("content should only carry the answer: %q", content) }
+}
+
+func TestMaxToolCalls_DropOrFail(t *testing.T) {
+    oresp := ad.OpenAIChatResponse{
+        Choices: []struct{ Index int `json:"index"`; FinishReason string `json:"finish_reason"`; Message ad.OpenAIMessage `json:"message"` }{
+            {Index:0, FinishReason:"tool_calls", Message: ad.OpenAIMessage{Role:"assistant", ToolCalls: []ad.OpenAIToolCall{
+                {ID:"t1", Type:"function", Function: ad.OpenAIToolCallFunction{Name:"a", Arguments:"{}"}},
+                {ID:"t2", Type:"function", Function: ad.OpenAIToolCallFunction{Name:"b", Arguments:"{}"}},
+                {ID:"t3", Type:"function", Function: ad.OpenAIToolCallFunction{Name:"c", Arguments:"{}"}},
+            }}},
+        },
+    }
+    var warnings int
+    opts := ad.Options{MaxToolCalls: 2, Warn: func(kind, detail string) { if kind == "tool_calls_truncated" { warnings++ } }}
+    aresp, err := ad.OpenAIToAnthropic(oresp, "claude-x", opts)
+    if err != nil { t.Fatalf("OpenAIToAnthropic: %v", err) }
+    if len(aresp.Content) != 2 || aresp.Content[1]["id"] != "t2" { t.Fatalf("expected first two tool calls: %#v", aresp.Content) }
+    if warnings == 0 { t.Fatalf("expected a truncation warning") }
+
+    opts.FailOnMaxToolCalls = true
+    if _, err := ad.OpenAIToAnthropic(oresp, "claude-x", opts); !errors.Is(err, ad.ErrTooManyToolCalls) { t.Fatalf("expected ErrTooManyToolCalls, got %v", err) }
+
+    var blocks []map[string]interface{}
+    _ = json.Unmarshal([]byte(`[{"type":"tool_use","id":"u1","name":"a","input":{}},{"type":"tool_use","id":"u2","name":"b","input":{}},{"type":"tool_use","id":"u3","name":"c","input":{}}]`), &blocks)
+    o2, err := ad.AnthropicToOpenAIResponse(ad.AnthropicMessageResponse{ID: "msg", Content: blocks}, "gpt-x", ad.Options{MaxToolCalls: 1})
+    if err != nil { t.Fatalf("AnthropicToOpenAIResponse: %v", err) }
+    if len(o2.Choices[0].Message.ToolCalls) != 1 || o2.Choices[0].Message.ToolCalls[0].ID != "u1" { t.Fatalf("expected one tool call: %#v", o2.Choices[0].Message.ToolCalls) }
+}
+
+func TestMaxToolCalls_Streaming(t *testing.T) {
+    s := ""+
+        "data: {\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"id\":\"call_a\",\"index\":0,\"function\":{\"name\":\"alpha\",\"arguments\":\"{}\"}}]}}]}\n\n"+
+        "data: {\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"id\":\"call_b\",\"index\":1,\"function\":{\"name\":\"beta\",\"arguments\":\"{}\"}}]}}]}\n\n"+
+        "data: [DONE]\n\n"
+    var names []string
+    err := ad.ConvertOpenAIStreamToAnthropic(context.Background(), "claude-x", strings.NewReader(s), func(event string, payload interface{}){
+        if event != "content_block_start" { return }
+        cb := payload.(map[string]interface{})["content_block"].(map[string]interface{})
+        names = append(names, cb["name"].(string))
+    }, ad.Options{MaxToolCalls: 1, FailOnMaxToolCalls: true})
+    if strings.Join(names, ",") != "alpha" { t.Fatalf("expected only alpha: %v", names) }
+    if !errors.Is(err, ad.ErrTooManyToolCalls) { t.Fatalf("expected ErrTooManyToolCalls, got %v", err) }
+
+    a := ""+
+        "event: content_block_start\n"+
+        "data: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"tool_use\",\"id\":\"u1\",\"name\":\"alpha\"}}\n\n"+
+        "event: content_block_start\n"+
+        "data: {\"type\":\"content_block_start\",\"index\":1,\"content_block\":{\"type\":\"tool_use\",\"id\":\"u2\",\"name\":\"beta\"}}\n\n"+
+        "event: content_block_delta\n"+
+        "data: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"{}\"}}\n\n"
+    var tools int
+    _ = ad.ConvertAnthropicStreamToOpenAI(context.Background(), "gpt-x", strings.NewReader(a), func(m map[string]interface{}){
+        d := m["choices"].([]map[string]interface{})[0]["delta"].(map[string]interface{})
+        if _, ok := d["tool_calls"]; ok { tools++ }
+    }, ad.Options{MaxToolCalls: 1})
+    if tools != 1 { t.Fatalf("expected only the first tool's chunk, got %d", tools) }
+}
+
+func TestConvertOpenAIStreamToAnthropic_ToolOnlyStartsAtIndexZero(t *testing.T) {
+    s := ""+
+        "data: {\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"id\":\"call_1\",\"type\":\"function\",\"index\":0,\"function\":{\"name\":\"sum\",\"arguments\":\"{}\"}}]}}]}\n\n"+
+        "data: [DONE]\n\n"
+    var starts []interface{}
+    _ = ad.ConvertOpenAIStreamToAnthropic(context.Background(), "claude-x", strings.NewReader(s), func(event string, payload interface{}){
+        if event == "content_block_start" { starts = append(starts, payload.(map[string]interface{})["index"]) }
+    })
+    if len(starts) != 1 || starts[0] != 0 { t.Fatalf("tool-only stream should start at index 0: %v", starts) }
+}
+
+func TestStreams_ShareOneResponseID(t *testing.T) {
+    a := ""+
+        "event: message_start\n"+
+        "data: {\"type\":\"message_start\",\"message\":{}}\n\n"+
+        "event: content_block_delta\n"+
+        "data: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"A\"}}\n\n"+
+        "event: content_block_delta\n"+
+        "data: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"B\"}}\n\n"+
+        "event: message_stop\n"+
+        "data: {\"type\":\"message_stop\"}\n\n"
+    ids := map[interface{}]bool{}
+    n := 0
+    _ = ad.ConvertAnthropicStreamToOpenAI(context.Background(), "gpt-x", strings.NewReader(a), func(m map[string]interface{}){ ids[m["id"]] = true; n++ })
+    if n < 3 || len(ids) != 1 { t.Fatalf("expected %d chunks sharing one id, got ids %v", n, ids) }
+
+    o := "data: {\"id\":\"chatcmpl-upstream\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hi\"}}]}\n\ndata: [DONE]\n\n"
+    var msgID interface{}
+    _ = ad.ConvertOpenAIStreamToAnthropic(context.Background(), "claude-x", strings.NewReader(o), func(event string, payload interface{}){
+        if event == "message_start" { msgID = payload.(map[string]interface{})["message"].(map[string]interface{})["id"] }
+    })
+    if msgID != "chatcmpl-upstream" { t.Fatalf("message_start should reuse upstream id, got %v", msgID) }
+}
+
+func TestAnthropicToOpenAIResponse_DocumentBlock(t *testing.T) {
+    var blocks []map[string]interface{}
+    _ = json.Unmarshal([]byte(`[
+        {"type":"text","text":"See attached."},
+        {"type":"document","title":"Notes","source":{"type":"text","media_type":"text/plain","data":"line one"}},
+        {"type":"document","source":{"type":"url","url":"https://example.com/a.pdf"}}
+    ]`), &blocks)
+    oresp, err := ad.AnthropicToOpenAIResponse(ad.AnthropicMessageResponse{ID: "msg_doc", Content: blocks}, "gpt-x")
+    if err != nil { t.Fatalf("AnthropicToOpenAIResponse: %v", err) }
+    got, _ := oresp.Choices[0].Message.Content.(string)
+    want := "See attached.\n\n[document: Notes]\nline one\n\n[document: https://example.com/a.pdf]"
+    if got != want { t.Fatalf("content:\n got %q\nwant %q", got, want) }
+}
+
+func TestStopSequences_CappedPerTarget(t *testing.T) {
+    stops := []string{"a", "b", "c", "d", "e", "f"}
+    var warned []string
+    opts := ad.Options{Warn: func(kind, detail string) { warned = append(warned, kind) }}
+
+    oreq, err := ad.AnthropicToOpenAI(ad.AnthropicMessageRequest{Model: "claude-x", StopSequences: stops, Messages: []ad.AnthropicMsg{{Role: "user", Content: json.RawMessage(`"hi"`)}}}, opts)
+    if err != nil { t.Fatalf("AnthropicToOpenAI: %v", err) }
+    if len(oreq.Stop) != ad.OpenAIMaxStopSequences || oreq.Stop[3] != "d" { t.Fatalf("OpenAI stops not capped at %d: %v", ad.OpenAIMaxStopSequences, oreq.Stop) }
+
+    areq, err := ad.OpenAIToAnthropicRequest(ad.OpenAIChatRequest{Model: "gpt-x", Stop: stops, Messages: []ad.OpenAIMessage{{Role: "user", Content: "hi"}}}, opts)
+    if err != nil { t.Fatalf("OpenAIToAnthropicRequest: %v", err) }
+    if len(areq.StopSequences) != len(stops) { t.Fatalf("Anthropic stops should be uncapped by default: %v", areq.StopSequences) }
+
+    opts.MaxStopSequences = 2
+    areq, _ = ad.OpenAIToAnthropicRequest(ad.OpenAIChatRequest{Model: "gpt-x", Stop: stops, Messages: []ad.OpenAIMessage{{Role: "user", Content: "hi"}}}, opts)
+    if len(areq.StopSequences) != 2 { t.Fatalf("configured cap ignored: %v", areq.StopSequences) }
+    if len(warned) != 2 || warned[0] != "stop_sequences_truncated" { t.Fatalf("expected truncation warnings, got %v", warned) }
+}
+
+func TestConvertMessagesToOpenAI_ImageOnlyToolResult(t *testing.T) {
+    areq := ad.AnthropicMessageRequest{Messages: []ad.AnthropicMsg{
+        {Role: "assistant", Content: json.RawMessage(`[{"type":"tool_use","id":"tu_1","name":"screenshot","input":{}}]`)},
+        {Role: "user", Content: json.RawMessage(`[{"type":"tool_result","tool_use_id":"tu_1","content":[{"type":"image","source":{"type":"base64","media_type":"image/png","data":"iVBORw0K"}}]}]`)},
+    }}
+    msgs, err := ad.ConvertMessagesToOpenAI(areq)
+    if err != nil { t.Fatalf("ConvertMessagesToOpenAI: %v", err) }
+    if len(msgs) != 3 { t.Fatalf("want assistant, tool, user messages; got %#v", msgs) }
+    if msgs[1].Role != "tool" || msgs[1].ToolCallID != "tu_1" || msgs[1].Content != "[image result]" { t.Fatalf("tool message: %#v", msgs[1]) }
+    parts, _ := msgs[2].Content.([]interface{})
+    if msgs[2].Role != "user" || len(parts) != 1 { t.Fatalf("image message: %#v", msgs[2]) }
+    img, _ := parts[0].(map[string]interface{})["image_url"].(map[string]interface{})
+    if img["url"] != "data:image/png;base64,iVBORw0K" { t.Fatalf("image url: %#v", parts[0]) }
+}
+
+func TestNormalizeToolPaths(t *testing.T) {
+    oresp := ad.OpenAIChatResponse{}
+    _ = json.Unmarshal([]byte(`{"choices":[{"finish_reason":"tool_calls","message":{"role":"assistant","tool_calls":[{"id":"call_1","type":"function","function":{"name":"Read","arguments":"{\"path\":\"C:\\\\Users\\\\me\\\\a.png\",\"pattern\":\"a\\\\b\"}"}}]}}]}`), &oresp)
+
+    aresp, _ := ad.OpenAIToAnthropic(oresp, "claude-x")
+    if in := aresp.Content[0]["input"].(map[string]interface{}); in["path"] != `C:\Users\me\a.png` { t.Fatalf("paths must be untouched by default: %#v", in) }
+
+    aresp, err := ad.OpenAIToAnthropic(oresp, "claude-x", ad.Options{NormalizeToolPaths: true})
+    if err != nil { t.Fatalf("OpenAIToAnthropic: %v", err) }
+    in := aresp.Content[0]["input"].(map[string]interface{})
+    if in["path"] != "C:/Users/me/a.png" { t.Fatalf("path not normalized: %#v", in) }
+    if in["pattern"] != `a\b` { t.Fatalf("non-path argument changed: %#v", in) }
+
+    back, _ := ad.AnthropicToOpenAIResponse(ad.AnthropicMessageResponse{Content: []map[string]interface{}{{"type": "tool_use", "id": "tu_1", "name": "Edit", "input": map[string]interface{}{"file_path": `src\main.go`}}}}, "gpt-x", ad.Options{NormalizeToolPaths: true})
+    if args := back.Choices[0].Message.ToolCalls[0].Function.Arguments; args != `{"file_path":"src/main.go"}` { t.Fatalf("file_path not normalized: %s", args) }
+}
+
+func TestConvertAnthropicStreamToOpenAI_FinishReasonFromMessageDelta(t *testing.T) {
+    finishFor := func(stopReason string) interface{} {
+        s := "event: content_block_delta\n" +
+            "data: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"partial\"}}\n\n" +
+            "event: message_delta\n" +
+            "data: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"" + stopReason + "\"},\"usage\":{\"output_tokens\":16}}\n\n" +
+            "event: message_stop\n" +
+            "data: {\"type\":\"message_stop\"}\n\n"
+        var last map[string]interface{}
+        _ = ad.ConvertAnthropicStreamToOpenAI(context.Background(), "gpt-x", strings.NewReader(s), func(m map[string]interface{}) { last = m })
+        return last["choices"].([]map[string]interface{})[0]["finish_reason"]
+    }
+    if got := finishFor("max_tokens"); got != "length" { t.Fatalf("max_tokens: finish_reason %v, want length", got) }
+    if got := finishFor("tool_use"); got != "tool_calls" { t.Fatalf("tool_use: finish_reason %v, want tool_calls", got) }
+    if got := finishFor("end_turn"); got != "stop" { t.Fatalf("end_turn: finish_reason %v, want stop", got) }
+}
+
+func TestToolCallIDs_GeneratedWhenMissing(t *testing.T) {
+    oreq := ad.OpenAIChatRequest{Model: "gpt-x", Messages: []ad.OpenAIMessage{
+        {Role: "user", Content: "read it"},
+        {Role: "assistant", ToolCalls: []ad.OpenAIToolCall{{Type: "function", Function: ad.OpenAIToolCallFunction{Name: "Read", Arguments: `{"path":"a"}`}}}},
+        {Role: "tool", Content: "contents"},
+    }}
+    areq, err := ad.OpenAIToAnthropicRequest(oreq)
+    if err != nil { t.Fatalf("OpenAIToAnthropicRequest: %v", err) }
+    var use, result []ad.AnthropicContent
+    _ = json.Unmarshal(areq.Messages[1].Content, &use)
+    _ = json.Unmarshal(areq.Messages[2].Content, &result)
+    if !strings.HasPrefix(use[0].ID, "call_") { t.Fatalf("tool_use id not generated: %q", use[0].ID) }
+    if result[0].ToolUseID != use[0].ID { t.Fatalf("tool_result id %q does not match tool_use id %q", result[0].ToolUseID, use[0].ID) }
+    again, _ := ad.OpenAIToAnthropicRequest(oreq)
+    if string(again.Messages[1].Content) != string(areq.Messages[1].Content) { t.Fatalf("generated id not stable across conversions") }
+
+    msgs, err := ad.ConvertMessagesToOpenAI(ad.AnthropicMessageRequest{Messages: []ad.AnthropicMsg{
+        {Role: "assistant", Content: json.RawMessage(`[{"type":"tool_use","name":"Read","input":{"path":"a"}}]`)},
+        {Role: "user", Content: json.RawMessage(`[{"type":"tool_result","content":"contents"}]`)},
+    }})
+    if err != nil { t.Fatalf("ConvertMessagesToOpenAI: %v", err) }
+    id := msgs[0].ToolCalls[0].ID
+    if !strings.HasPrefix(id, "call_") || msgs[1].ToolCallID != id { t.Fatalf("ids: tool_call %q, tool message %q", id, msgs[1].ToolCallID) }
+}
+
+func TestConvertOpenAIStreamToAnthropic_ToolIDAfterName(t *testing.T) {
+    s := "data: {\"id\":\"c1\",\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"type\":\"function\",\"function\":{\"name\":\"Read\",\"arguments\":\"{\\\"pa\"}}]}}]}\n\n" +
+        "data: {\"id\":\"c1\",\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"id\":\"call_late\",\"function\":{\"arguments\":\"th\\\":\\\"a\\\"}\"}}]}}]}\n\n" +
+        "data: {\"id\":\"c1\",\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":1,\"function\":{\"name\":\"Glob\",\"arguments\":\"{}\"}}]}}]}\n\n" +
+        "data: [DONE]\n\n"
+    var starts []map[string]interface{}
+    args := map[interface{}]string{}
+    _ = ad.ConvertOpenAIStreamToAnthropic(context.Background(), "claude-x", strings.NewReader(s), func(event string, payload interface{}) {
+        p := payload.(map[string]interface{})
+        switch event {
+        case "content_block_start":
+            starts = append(starts, p["content_block"].(map[string]interface{}))
+        case "content_block_delta":
+            args[p["index"]] += fmt.Sprint(p["delta"].(map[string]interface{})["partial_json"])
+        }
+    })
+    if len(starts) != 2 { t.Fatalf("want 2 tool blocks, got %#v", starts) }
+    if starts[0]["id"] != "call_late" || starts[0]["name"] != "Read" { t.Fatalf("first block: %#v", starts[0]) }
+    if args[0] != `{"path":"a"}` { t.Fatalf("first block args: %q", args[0]) }
+    if id, _ := starts[1]["id"].(string); !strings.HasPrefix(id, "call_") { t.Fatalf("id-less tool block should get a generated id: %#v", starts[1]) }
+}
+
+func TestConvertOpenAIStreamToAnthropic_ReportUpstreamModel(t *testing.T) {
+    s := "data: {\"id\":\"c1\",\"model\":\"gpt-4o-2024-08-06\",\"choices\":[{\"delta\":{\"content\":\"Hi\"}}]}\n\ndata: [DONE]\n\n"
+    models := func(opts ...ad.Options) (start, delta interface{}) {
+        _ = ad.ConvertOpenAIStreamToAnthropic(context.Background(), "claude-x", strings.NewReader(s), func(event string, payload interface{}) {
+            p := payload.(map[string]interface{})
+            if event == "message_start" { start = p["message"].(map[string]interface{})["model"] }
+            if event == "message_delta" { delta = p["model"] }
+        }, opts...)
+        return
+    }
+    if start, delta := models(); start != "claude-x" || delta != nil { t.Fatalf("default should report requested model only: start=%v delta=%v", start, delta) }
+    if start, delta := models(ad.Options{ReportUpstreamModel: true}); start != "gpt-4o-2024-08-06" || delta != "gpt-4o-2024-08-06" { t.Fatalf("upstream model not reported: start=%v delta=%v", start, delta) }
+
+    noModel := "data: {\"id\":\"c1\",\"choices\":[{\"delta\":{\"content\":\"Hi\"}}]}\n\ndata: [DONE]\n\n"
+    var start interface{}
+    _ = ad.ConvertOpenAIStreamToAnthropic(context.Background(), "claude-x", strings.NewReader(noModel), func(event string, payload interface{}) {
+        if event == "message_start" { start = payload.(map[string]interface{})["message"].(map[string]interface{})["model"] }
+    }, ad.Options{ReportUpstreamModel: true})
+    if start != "claude-x" { t.Fatalf("should fall back to requested model, got %v", start) }
+}
+
+func TestOpenAIToAnthropicRequest_MergesAdjacentSameRole(t *testing.T) {
+    oreq := ad.OpenAIChatRequest{Model: "gpt-x", Messages: []ad.OpenAIMessage{
+        {Role: "user", Content: "read it"},
+        {Role: "assistant", ToolCalls: []ad.OpenAIToolCall{{ID: "call_1", Type: "function", Function: ad.OpenAIToolCallFunction{Name: "Read", Arguments: `{}`}}}},
+        {Role: "user", Content: "before"},
+        {Role: "tool", ToolCallID: "call_1", Content: "result"},
+        {Role: "user", Content: "after"},
+    }}
+    areq, err := ad.OpenAIToAnthropicRequest(oreq)
+    if err != nil { t.Fatalf("OpenAIToAnthropicRequest: %v", err) }
+    if len(areq.Messages) != 3 || areq.Messages[2].Role != "user" { t.Fatalf("want user, assistant, one merged user message; got %d: %#v", len(areq.Messages), areq.Messages) }
+    var blocks []ad.AnthropicContent
+    _ = json.Unmarshal(areq.Messages[2].Content, &blocks)
+    if len(blocks) != 3 || blocks[0].Text != "before" || blocks[1].Type != "tool_result" || blocks[1].ToolUseID != "call_1" || blocks[2].Text != "after" { t.Fatalf("merged blocks out of order: %#v", blocks) }
+}
+
+func TestOpenAIToAnthropicRequest_PrependsPlaceholderUser(t *testing.T) {
+    oreq := ad.OpenAIChatRequest{Model: "gpt-x", Messages: []ad.OpenAIMessage{
+        {Role: "system", Content: "be brief"},
+        {Role: "assistant", Content: "Hello, how can I help?"},
+        {Role: "user", Content: "hi"},
+    }}
+    areq, err := ad.OpenAIToAnthropicRequest(oreq)
+    if err != nil { t.Fatalf("OpenAIToAnthropicRequest: %v", err) }
+    if len(areq.Messages) != 3 || areq.Messages[0].Role != "user" || areq.Messages[1].Role != "assistant" { t.Fatalf("want injected leading user turn, got %#v", areq.Messages) }
+    var blocks []ad.AnthropicContent
+    _ = json.Unmarshal(areq.Messages[0].Content, &blocks)
+    if len(blocks) != 1 || blocks[0].Text != "." { t.Fatalf("default placeholder: %#v", blocks) }
+
+    areq, _ = ad.OpenAIToAnthropicRequest(oreq, ad.Options{PlaceholderUserText: "(continue)"})
+    _ = json.Unmarshal(areq.Messages[0].Content, &blocks)
+    if blocks[0].Text != "(continue)" { t.Fatalf("configured placeholder ignored: %#v", blocks) }
+}
+
+func TestOpenAIToAnthropicRequest_AssistantRefusalPart(t *testing.T) {
+    oreq := ad.OpenAIChatRequest{Model: "gpt-x", Messages: []ad.OpenAIMessage{
+        {Role: "user", Content: "do the bad thing"},
+        {Role: "assistant", Content: []interface{}{map[string]interface{}{"type": "refusal", "refusal": "I can't help with that."}}},
+        {Role: "user", Content: "ok, something else"},
+    }}
+    areq, err := ad.OpenAIToAnthropicRequest(oreq)
+    if err != nil { t.Fatalf("OpenAIToAnthropicRequest: %v", err) }
+    if len(areq.Messages) != 3 || areq.Messages[1].Role != "assistant" { t.Fatalf("assistant refusal turn dropped: %#v", areq.Messages) }
+    var parts []ad.AnthropicContent
+    _ = json.Unmarshal(areq.Messages[1].Content, &parts)
+    if len(parts) != 1 || parts[0].Type != "text" || parts[0].Text != "[refusal] I can't help with that." { t.Fatalf("refusal not preserved: %#v", parts) }
+}
+
+func TestOpenAIToAnthropicRequest_DeveloperRole(t *testing.T) {
+    oreq := ad.OpenAIChatRequest{Model: "gpt-x", Messages: []ad.OpenAIMessage{
+        {Role: "developer", Content: "Answer in French."},
+        {Role: "user", Content: "hello"},
+    }}
+    areq, err := ad.OpenAIToAnthropicRequest(oreq)
+    if err != nil { t.Fatalf("OpenAIToAnthropicRequest: %v", err) }
+    var system string
+    _ = json.Unmarshal(areq.System, &system)
+    if system != "Answer in French." { t.Fatalf("developer text not in system prompt: %q", string(areq.System)) }
+    if len(areq.Messages) != 1 || areq.Messages[0].Role != "user" { t.Fatalf("developer message leaked into messages: %#v", areq.Messages) }
+
+    oreq.Messages = append([]ad.OpenAIMessage{{Role: "system", Content: "Be brief."}}, oreq.Messages...)
+    areq, _ = ad.OpenAIToAnthropicRequest(oreq)
+    _ = json.Unmarshal(areq.System, &system)
+    if system != "Be brief.\n\nAnswer in French." { t.Fatalf("system and developer should both be kept: %q", system) }
+}
+
+// checkBlockSequence validates Anthropic stream events: block indices start at 0,
+// increase by one per content_block_start, and each block is stopped (with the
+// same index) before the next one starts. It returns the block types in order.
+func checkBlockSequence(t *testing.T, events []string, payloads []map[string]interface{}) []string {
+    t.Helper()
+    var types []string
+    open := -1
+    for i, ev := range events {
+        p := payloads[i]
+        switch ev {
+        case "content_block_start":
+            idx := p["index"].(int)
+            if open >= 0 { t.Fatalf("event %d: block %d started while block %d still open", i, idx, open) }
+            if idx != len(types) { t.Fatalf("event %d: block index %d, want contiguous %d", i, idx, len(types)) }
+            open = idx
+            types = append(types, p["content_block"].(map[string]interface{})["type"].(string))
+        case "content_block_delta":
+            if idx := p["index"].(int); idx != open { t.Fatalf("event %d: delta for block %d while block %d open", i, idx, open) }
+        case "content_block_stop":
+            if idx := p["index"].(int); idx != open { t.Fatalf("event %d: stop for block %d, want %d", i, idx, open) }
+            open = -1
+        }
+    }
+    if open >= 0 { t.Fatalf("block %d never stopped", open) }
+    return types
+}
+
+func TestConvertOpenAIStreamToAnthropic_IndexHarness(t *testing.T) {
+    chunk := func(delta string) string { return "data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":" + delta + "}]}\n\n" }
+    s := chunk(`{"role":"assistant","content":"Let me look."}`) +
+        chunk(`{"content":" One sec."}`) +
+        chunk(`{"tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"name":"Read","arguments":"{\"path\":"}}]}`) +
+        chunk(`{"tool_calls":[{"index":0,"function":{"arguments":"\"a\"}"}}]}`) +
+        chunk(`{"content":"Now two more."}`) +
+        chunk(`{"tool_calls":[{"index":1,"id":"call_b","type":"function","function":{"name":"Glob","arguments":"{\"pattern\":\"*.go\"}"}}]}`) +
+        chunk(`{"tool_calls":[{"index":2,"id":"call_c","type":"function","function":{"name":"Grep","arguments":""}}]}`) +
+        chunk(`{"tool_calls":[{"index":2,"function":{"arguments":"{\"q\":\"x\"}"}}]}`) +
+        "data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"tool_calls\"}]}\n\n" +
+        "data: [DONE]\n\n"
+    var events []string
+    var payloads []map[string]interface{}
+    if err := ad.ConvertOpenAIStreamToAnthropic(context.Background(), "claude-x", strings.NewReader(s), func(event string, payload interface{}) {
+        events = append(events, event)
+        payloads = append(payloads, payload.(map[string]interface{}))
+    }); err != nil { t.Fatalf("convert: %v", err) }
+    if events[0] != "message_start" || events[len(events)-1] != "message_stop" { t.Fatalf("envelope: %v", events) }
+    types := checkBlockSequence(t, events, payloads)
+    want := []string{"text", "tool_use", "text", "tool_use", "tool_use"}
+    if fmt.Sprint(types) != fmt.Sprint(want) { t.Fatalf("block types %v, want %v", types, want) }
+    args := map[int]string{}
+    for i, ev := range events {
+        if ev != "content_block_delta" { continue }
+        if pj, ok := payloads[i]["delta"].(map[string]interface{})["partial_json"].(string); ok { args[payloads[i]["index"].(int)] += pj }
+    }
+    if args[1] != `{"path":"a"}` || args[3] != `{"pattern":"*.go"}` || args[4] != `{"q":"x"}` { t.Fatalf("tool args by block: %#v", args) }
+}
+
+func TestConvertMessagesToOpenAI_AssistantPrefillPreserved(t *testing.T) {
+    areq := ad.AnthropicMessageRequest{Model: "claude-x", Messages: []ad.AnthropicMsg{
+        {Role: "user", Content: json.RawMessage(`"Give me JSON for a cat."`)},
+        {Role: "assistant", Content: json.RawMessage(`[{"type":"text","text":"{\"name\": "},{"type":"text","text":"\""}]`)},
+    }}
+    oreq, err := ad.AnthropicToOpenAI(areq)
+    if err != nil { t.Fatalf("AnthropicToOpenAI: %v", err) }
+    last := oreq.Messages[len(oreq.Messages)-1]
+    if last.Role != "assistant" || last.Content != `{"name": "` { t.Fatalf("prefill not preserved verbatim: %#v", last) }
+
+    areq.Messages[1].Content = json.RawMessage(`"The answer is"`)
+    oreq, _ = ad.AnthropicToOpenAI(areq)
+    if last := oreq.Messages[len(oreq.Messages)-1]; last.Role != "assistant" || last.Content != "The answer is" { t.Fatalf("string prefill changed: %#v", last) }
+}
+
+func TestTemperature_ClampedOrScaledToTargetRange(t *testing.T) {
+    f := func(v float64) *float64 { return &v }
+    oreq := ad.OpenAIChatRequest{Model: "gpt-x", Temperature: f(1.8), Messages: []ad.OpenAIMessage{{Role: "user", Content: "hi"}}}
+    areq, _ := ad.OpenAIToAnthropicRequest(oreq)
+    if areq.Temperature == nil || *areq.Temperature != 1 { t.Fatalf("1.8 should clamp to 1 for Anthropic, got %v", areq.Temperature) }
+    areq, _ = ad.OpenAIToAnthropicRequest(oreq, ad.Options{ScaleTemperature: true})
+    if areq.Temperature == nil || *areq.Temperature != 0.9 { t.Fatalf("1.8 should scale to 0.9 for Anthropic, got %v", areq.Temperature) }
+
+    back, _ := ad.AnthropicToOpenAI(ad.AnthropicMessageRequest{Model: "claude-x", Temperature: f(0.7)})
+    if back.Temperature == nil || *back.Temperature != 0.7 { t.Fatalf("in-range value should pass to OpenAI unchanged, got %v", back.Temperature) }
+    back, _ = ad.AnthropicToOpenAI(ad.AnthropicMessageRequest{Model: "claude-x", Temperature: f(0.7)}, ad.Options{ScaleTemperature: true})
+    if back.Temperature == nil || *back.Temperature != 1.4 { t.Fatalf("0.7 should scale to 1.4 for OpenAI, got %v", back.Temperature) }
+
+    oreq.Temperature = nil
+    if areq, _ = ad.OpenAIToAnthropicRequest(oreq); areq.Temperature != nil { t.Fatalf("nil temperature should stay nil, got %v", *areq.Temperature) }
+}
+
+func TestTopP_RoundTrip(t *testing.T) {
+    p := 0.9
+    oreq, _ := ad.AnthropicToOpenAI(ad.AnthropicMessageRequest{Model: "claude-x", TopP: &p})
+    if oreq.TopP == nil || *oreq.TopP != 0.9 { t.Fatalf("top_p lost toward OpenAI: %v", oreq.TopP) }
+    areq, _ := ad.OpenAIToAnthropicRequest(ad.OpenAIChatRequest{Model: "gpt-x", TopP: &p, Messages: []ad.OpenAIMessage{{Role: "user", Content: "hi"}}})
+    if areq.TopP == nil || *areq.TopP != 0.9 { t.Fatalf("top_p lost toward Anthropic: %v", areq.TopP) }
+    b, _ := json.Marshal(areq)
+    if !strings.Contains(string(b), `"top_p":0.9`) { t.Fatalf("top_p not marshaled: %s", b) }
+    b, _ = json.Marshal(ad.OpenAIChatRequest{Model: "gpt-x"})
+    if strings.Contains(string(b), "top_p") { t.Fatalf("nil top_p should be omitted: %s", b) }
+}
+
+func TestAnthropicToOpenAI_DropsTopK(t *testing.T) {
+    k := 40
+    var warned string
+    oreq, err := ad.AnthropicToOpenAI(ad.AnthropicMessageRequest{Model: "claude-x", TopK: &k, Messages: []ad.AnthropicMsg{{Role: "user", Content: json.RawMessage(`"hi"`)}}}, ad.Options{Warn: func(kind, detail string) { warned = kind }})
+    if err != nil { t.Fatalf("AnthropicToOpenAI: %v", err) }
+    b, _ := json.Marshal(oreq)
+    if strings.Contains(string(b), "top_k") { t.Fatalf("top_k leaked into OpenAI request: %s", b) }
+    if warned != "unsupported_param" { t.Fatalf("expected a drop warning, got %q", warned) }
+}
+
+func TestSystemPrompt_WhitespacePreservedOrTrimmed(t *testing.T) {
+    const padded = "\n  Example:\n    Q: 1+1\n    A: 2\n\n"
+    sys, _ := json.Marshal(padded)
+    areq := ad.AnthropicMessageRequest{Model: "claude-x", System: sys, Messages: []ad.AnthropicMsg{{Role: "user", Content: json.RawMessage(`"hi"`)}}}
+    oreq, _ := ad.AnthropicToOpenAI(areq)
+    if oreq.Messages[0].Content != padded { t.Fatalf("system prompt not forwarded verbatim: %q", oreq.Messages[0].Content) }
+    oreq, _ = ad.AnthropicToOpenAI(areq, ad.Options{TrimSystem: true})
+    if oreq.Messages[0].Content != strings.TrimSpace(padded) { t.Fatalf("system prompt not trimmed: %q", oreq.Messages[0].Content) }
+
+    in := ad.OpenAIChatRequest{Model: "gpt-x", Messages: []ad.OpenAIMessage{{Role: "system", Content: padded}, {Role: "user", Content: "hi"}}}
+    var got string
+    back, _ := ad.OpenAIToAnthropicRequest(in)
+    _ = json.Unmarshal(back.System, &got)
+    if got != padded { t.Fatalf("system prompt not forwarded verbatim to Anthropic: %q", got) }
+    back, _ = ad.OpenAIToAnthropicRequest(in, ad.Options{TrimSystem: true})
+    _ = json.Unmarshal(back.System, &got)
+    if got != strings.TrimSpace(padded) { t.Fatalf("system prompt not trimmed for Anthropic: %q", got) }
+}
+
+func TestOpenAIToAnthropicRequest_BlankStopSequencesDropped(t *testing.T) {
+    var warned []string
+    opts := ad.Options{Warn: func(kind, detail string) { warned = append(warned, kind) }}
+    areq, err := ad.OpenAIToAnthropicRequest(ad.OpenAIChatRequest{Model: "gpt-x", Stop: []string{"", "END", " \n"}, Messages: []ad.OpenAIMessage{{Role: "user", Content: "hi"}}}, opts)
+    if err != nil { t.Fatalf("OpenAIToAnthropicRequest: %v", err) }
+    if len(areq.StopSequences) != 1 || areq.StopSequences[0] != "END" { t.Fatalf("blank stops not filtered: %q", areq.StopSequences) }
+    if len(warned) != 2 || warned[0] != "stop_sequence_dropped" { t.Fatalf("expected a warning per dropped stop, got %v", warned) }
+
+    areq, _ = ad.OpenAIToAnthropicRequest(ad.OpenAIChatRequest{Model: "gpt-x", Stop: []string{""}, Messages: []ad.OpenAIMessage{{Role: "user", Content: "hi"}}})
+    b, _ := json.Marshal(areq)
+    if strings.Contains(string(b), "stop_sequences") { t.Fatalf("all-blank stops should omit stop_sequences: %s", b) }
+}
+
+func TestOpenAIToAnthropicRequest_PenaltiesDropped(t *testing.T) {
+    var oreq ad.OpenAIChatRequest
+    if err := json.Unmarshal([]byte(`{"model":"gpt-x","presence_penalty":0.5,"frequency_penalty":-0.25,"messages":[{"role":"user","content":"hi"}]}`), &oreq); err != nil { t.Fatalf("unmarshal: %v", err) }
+    var warned []string
+    areq, err := ad.OpenAIToAnthropicRequest(oreq, ad.Options{Warn: func(kind, detail string) { warned = append(warned, kind+": "+detail) }})
+    if err != nil { t.Fatalf("OpenAIToAnthropicRequest: %v", err) }
+    b, _ := json.Marshal(areq)
+    if strings.Contains(string(b), "penalty") { t.Fatalf("penalties leaked into Anthropic request: %s", b) }
+    if len(warned) != 2 || !strings.Contains(warned[0], "presence_penalty") || !strings.Contains(warned[1], "frequency_penalty") { t.Fatalf("expected drop notes for both penalties, got %v", warned) }
+}
+
+func TestOpenAIToAnthropicRequest_SeedDropped(t *testing.T) {
+    seed := 7
+    areq, err := ad.OpenAIToAnthropicRequest(ad.OpenAIChatRequest{Model: "gpt-x", Seed: &seed, Messages: []ad.OpenAIMessage{{Role: "user", Content: "hi"}}})
+    if err != nil { t.Fatalf("OpenAIToAnthropicRequest: %v", err) }
+    if areq.Seed != nil { t.Fatalf("seed should not be sent to Anthropic: %d", *areq.Seed) }
+}
+
+func TestOpenAIToAnthropicRequest_MergesToolsAndFunctions(t *testing.T) {
+    var oreq ad.OpenAIChatRequest
+    raw := `{"model":"gpt-x","messages":[{"role":"user","content":"hi"}],
+      "tools":[{"type":"function","function":{"name":"read","description":"from tools","parameters":{"type":"object"}}},{"type":"function","function":{"name":"write","parameters":{"type":"object"}}}],
+      "functions":[{"name":"read","description":"from functions"},{"name":"grep","description":"legacy only","parameters":{"type":"object"}}]}`
+    if err := json.Unmarshal([]byte(raw), &oreq); err != nil { t.Fatalf("unmarshal: %v", err) }
+    areq, err := ad.OpenAIToAnthropicRequest(oreq)
+    if err != nil { t.Fatalf("OpenAIToAnthropicRequest: %v", err) }
+    var names []string
+    for _, tl := range areq.Tools { names = append(names, tl.Name) }
+    if strings.Join(names, ",") != "read,write,grep" { t.Fatalf("merged tools = %v", names) }
+    if areq.Tools[0].Description != "from tools" { t.Fatalf("tools should win over functions: %q", areq.Tools[0].Description) }
+}
+
+func TestOpenAIToAnthropicRequest_JSONModePrompt(t *testing.T) {
+    var oreq ad.OpenAIChatRequest
+    if err := json.Unmarshal([]byte(`{"model":"gpt-x","response_format":{"type":"json_object"},"messages":[{"role":"system","content":"Be brief."},{"role":"user","content":"list colors"}]}`), &oreq); err != nil { t.Fatalf("unmarshal: %v", err) }
+    var sys string
+    areq, _ := ad.OpenAIToAnthropicRequest(oreq, ad.Options{JSONModePrompt: true})
+    _ = json.Unmarshal(areq.System, &sys)
+    if sys != "Be brief.\n\nRespond only with valid JSON." { t.Fatalf("system = %q", sys) }
+
+    areq, _ = ad.OpenAIToAnthropicRequest(oreq)
+    sys = ""
+    _ = json.Unmarshal(areq.System, &sys)
+    if sys != "Be brief." { t.Fatalf("instruction added without JSONModePrompt: %q", sys) }
+
+    oreq.ResponseFormat = &ad.OpenAIResponseFormat{Type: "json_schema", JSONSchema: &ad.OpenAIJSONSchema{Name: "colors", Schema: map[string]interface{}{"type": "array"}}}
+    areq, _ = ad.OpenAIToAnthropicRequest(oreq, ad.Options{JSONModePrompt: true})
+    _ = json.Unmarshal(areq.System, &sys)
+    if !strings.Contains(sys, "(colors)") || !strings.Contains(sys, `{"type":"array"}`) { t.Fatalf("schema not injected: %q", sys) }
+}
+
+func TestOpenAIToAnthropic_ArrayContentWithToolCalls(t *testing.T) {
+    var oresp ad.OpenAIChatResponse
+    raw := `{"choices":[{"index":0,"finish_reason":"tool_calls","message":{"role":"assistant",
+      "content":[{"type":"text","text":"Reading both."},{"type":"text","text":"One moment."}],
+      "tool_calls":[{"id":"call_a","type":"function","function":{"name":"read","arguments":"{\"path\":\"a\"}"}},{"id":"call_b","type":"function","function":{"name":"read","arguments":"{\"path\":\"b\"}"}}]}}]}`
+    if err := json.Unmarshal([]byte(raw), &oresp); err != nil { t.Fatalf("unmarshal: %v", err) }
+    aresp, err := ad.OpenAIToAnthropic(oresp, "claude-x")
+    if err != nil { t.Fatalf("OpenAIToAnthropic: %v", err) }
+    var order []string
+    for _, c := range aresp.Content {
+        if c["type"] == "text" { order = append(order, "text:"+c["text"].(string)) } else { order = append(order, fmt.Sprint(c["type"], ":", c["id"])) }
+    }
+    want := "text:Reading both.\n\nOne moment.|tool_use:call_a|tool_use:call_b"
+    if strings.Join(order, "|") != want { t.Fatalf("content order = %q", order) }
+    if aresp.StopReason == nil || *aresp.StopReason != "tool_use" { t.Fatalf("stop_reason = %v", aresp.StopReason) }
+}
+
+func TestParallelToolCalls_BothDirections(t *testing.T) {
+    on, off := true, false
+    for _, tc := range []struct {
+        name     string
+        parallel *bool
+        want     string
+    }{
+        {"unset", nil, ""},
+        {"true", &on, ""},
+        {"false", &off, `{"type":"auto","disable_parallel_tool_use":true}`},
+    } {
+        areq, err := ad.OpenAIToAnthropicRequest(ad.OpenAIChatRequest{Model: "gpt-x", ParallelToolCalls: tc.parallel, Messages: []ad.OpenAIMessage{{Role: "user", Content: "hi"}}})
+        if err != nil { t.Fatalf("%s: %v", tc.name, err) }
+        got := ""
+        if areq.ToolChoice != nil { b, _ := json.Marshal(areq.ToolChoice); got = string(b) }
+        if got != tc.want { t.Fatalf("%s: tool_choice = %s, want %s", tc.name, got, tc.want) }
+
+        // and back: only disable_parallel_tool_use surfaces as parallel_tool_calls:false
+        oreq, err := ad.AnthropicToOpenAI(ad.AnthropicMessageRequest{Model: "claude-x", ToolChoice: areq.ToolChoice, Messages: []ad.AnthropicMsg{{Role: "user", Content: json.RawMessage(`"hi"`)}}})
+        if err != nil { t.Fatalf("%s: %v", tc.name, err) }
+        b, _ := json.Marshal(oreq)
+        if wantOff := tc.want != ""; strings.Contains(string(b), `"parallel_tool_calls":false`) != wantOff || (!wantOff && strings.Contains(string(b), "parallel_tool_calls")) {
+            t.Fatalf("%s: OpenAI request = %s", tc.name, b)
+        }
+    }
+}
+
+func TestRoundTripEqual(t *testing.T) {
+    areq := ad.AnthropicMessageRequest{Model: "claude-x", MaxTokens: 64, System: json.RawMessage(`"Be terse."`), Messages: []ad.AnthropicMsg{
+        {Role: "user", Content: json.RawMessage(`"read a.txt"`)},
+        {Role: "assistant", Content: json.RawMessage(`[{"type":"text","text":"Reading."},{"type":"tool_use","id":"toolu_1","name":"read","input":{"path":"a.txt"}}]`)},
+        {Role: "user", Content: json.RawMessage(`[{"type":"tool_result","tool_use_id":"toolu_1","content":"hello"}]`)},
+        {Role: "assistant", Content: json.RawMessage(`"It says hello."`)},
+        {Role: "user", Content: json.RawMessage(`"thanks"`)},
+    }}
+    if ok, diff := ad.RoundTripEqual(areq); !ok { t.Fatalf("tool round trip not equal:\n%s", diff) }
+
+    k := 5
+    areq.TopK = &k // dropped toward OpenAI
+    ok, diff := ad.RoundTripEqual(areq)
+    if ok || !strings.Contains(diff, "top_k: 5 != null") { t.Fatalf("expected a top_k diff, got ok=%v diff=%q", ok, diff) }
+}
+
+func TestRequestExtraFields_RoundTripJSON(t *testing.T) {
+    var oreq ad.OpenAIChatRequest
+    if err := json.Unmarshal([]byte(`{"model":"gpt-x","x_vendor":{"a":1},"messages":[{"role":"user","content":"hi"}]}`), &oreq); err != nil { t.Fatalf("unmarshal: %v", err) }
+    if string(oreq.Extra["x_vendor"]) != `{"a":1}` || len(oreq.Extra) != 1 { t.Fatalf("Extra = %v", oreq.Extra) }
+    b, _ := json.Marshal(oreq)
+    if string(b) != `{"model":"gpt-x","messages":[{"role":"user","content":"hi"}],"x_vendor":{"a":1}}` { t.Fatalf("marshal = %s", b) }
+
+    // an extra named like a modeled field never overrides it
+    areq := ad.AnthropicMessageRequest{Model: "claude-x", Extra: map[string]json.RawMessage{"model": json.RawMessage(`"other"`)}}
+    b, _ = json.Marshal(areq)
+    if strings.Contains(string(b), "other") { t.Fatalf("extra shadowed a modeled field: %s", b) }
+}
+
+func TestOpenAIToAnthropicRequest_UserWithToolResultOrdering(t *testing.T) {
+    oreq := ad.OpenAIChatRequest{Model: "gpt-x", Messages: []ad.OpenAIMessage{
+        {Role: "user", Content: "A"},
+        {Role: "tool", ToolCallID: "call_1", Content: "RESULT"},
+        {Role: "user", Content: "B"},
+    }}
+    areq, err := ad.OpenAIToAnthropicRequest(oreq)
+    if err != nil { t.Fatalf("convert failed: %v", err) }
+    if len(areq.Messages) != 1 || areq.Messages[0].Role != "user" { t.Fatalf("expected one interleaved user turn, got %d (%#v)", len(areq.Messages), areq.Messages) }
+    var blocks []ad.AnthropicContent
+    if err := json.Unmarshal(areq.Messages[0].Content, &blocks); err != nil { t.Fatalf("content: %v", err) }
+    if len(blocks) != 3 { t.Fatalf("expected 3 blocks, got %d (%s)", len(blocks), areq.Messages[0].Content) }
+    if blocks[0].Type != "text" || blocks[0].Text != "A" { t.Fatalf("bad[0]: %#v", blocks[0]) }
+    if blocks[1].Type != "tool_result" || blocks[1].ToolUseID != "call_1" || blocks[1].Content != "RESULT" { t.Fatalf("bad[1]: %#v", blocks[1]) }
+    if blocks[2].Type != "text" || blocks[2].Text != "B" { t.Fatalf("bad[2]: %#v", blocks[2]) }
+
+    // and the interleaved turn converts back to the original sequence
+    msgs, err := ad.ConvertMessagesToOpenAI(areq)
+    if err != nil || len(msgs) != 3 || msgs[0].Content != "A" || msgs[1].ToolCallID != "call_1" || msgs[2].Content != "B" { t.Fatalf("reverse: %v %#v", err, msgs) }
+}
+
+func TestOpenAIToAnthropicRequest_EmptyAssistantTurn(t *testing.T) {
+    oreq := ad.OpenAIChatRequest{Model: "gpt-x", Messages: []ad.OpenAIMessage{
+        {Role: "user", Content: "one"},
+        {Role: "assistant", Content: ""},
+        {Role: "user", Content: "two"},
+    }}
+    var warned []string
+    areq, err := ad.OpenAIToAnthropicRequest(oreq, ad.Options{Warn: func(kind, detail string) { warned = append(warned, kind) }})
+    if err != nil { t.Fatalf("convert failed: %v", err) }
+    if len(areq.Messages) != 1 || string(areq.Messages[0].Content) != `[{"type":"text","text":"one"},{"type":"text","text":"two"}]` { t.Fatalf("default should drop the turn and merge users: %#v", areq.Messages) }
+    if len(warned) != 1 || warned[0] != "empty_assistant_turn" { t.Fatalf("warnings = %v", warned) }
+
+    areq, _ = ad.OpenAIToAnthropicRequest(oreq, ad.Options{EmptyAssistantText: "(no response)"})
+    var roles []string
+    for _, m := range areq.Messages { roles = append(roles, m.Role) }
+    if strings.Join(roles, ",") != "user,assistant,user" || string(areq.Messages[1].Content) != `[{"type":"text","text":"(no response)"}]` { t.Fatalf("placeholder turn: %v %s", roles, areq.Messages[1].Content) }
+}
+
+func TestAnthropicToOpenAI_MetadataFlattened(t *testing.T) {
+    var areq ad.AnthropicMessageRequest
+    raw := `{"model":"claude-x","max_tokens":16,"messages":[{"role":"user","content":"hi"}],
+      "metadata":{"user_id":"u1","session":{"id":"s9","attempt":2,"debug":true},"tags":["a","b"],"gone":null}}`
+    if err := json.Unmarshal([]byte(raw), &areq); err != nil { t.Fatalf("unmarshal: %v", err) }
+    oreq, err := ad.AnthropicToOpenAI(areq)
+    if err != nil { t.Fatalf("AnthropicToOpenAI: %v", err) }
+    want := map[string]string{"user_id": "u1", "session.id": "s9", "session.attempt": "2", "session.debug": "true", "tags": `["a","b"]`}
+    if !reflect.DeepEqual(oreq.Metadata, want) { t.Fatalf("metadata = %v", oreq.Metadata) }
+
+    var warned []string
+    areq.Metadata = json.RawMessage(`{"` + strings.Repeat("k", 65) + `":"v","ok":"` + strings.Repeat("v", 513) + `"}`)
+    oreq, _ = ad.AnthropicToOpenAI(areq, ad.Options{Warn: func(kind, detail string) { warned = append(warned, kind) }})
+    if oreq.Metadata != nil || len(warned) != 2 || warned[0] != "metadata_dropped" { t.Fatalf("oversized pairs kept: %v (warnings %v)", oreq.Metadata, warned) }
+
+    back, _ := ad.OpenAIToAnthropicRequest(ad.OpenAIChatRequest{Model: "gpt-x", Metadata: want, Messages: []ad.OpenAIMessage{{Role: "user", Content: "hi"}}})
+    if string(back.Metadata) != `{"user_id":"u1"}` { t.Fatalf("Anthropic metadata = %s", back.Metadata) }
+}
+
+func TestConvertOpenAIStreamToAnthropic_ContentAndToolInOneChunk(t *testing.T) {
+    collect := func(s string) []string {
+        var seq []string
+        _ = ad.ConvertOpenAIStreamToAnthropic(context.Background(), "claude-x", strings.NewReader(s), func(event string, payload interface{}){
+            m := payload.(map[string]interface{})
+            switch event {
+            case "content_block_start":
+                cb := m["content_block"].(map[string]interface{})
+                seq = append(seq, fmt.Sprintf("start:%v:%v", m["index"], cb["type"]))
+            case "content_block_delta":
+                d := m["delta"].(map[string]interface{})
+                seq = append(seq, fmt.Sprintf("delta:%v:%v%v", m["index"], d["text"], d["partial_json"]))
+            case "content_block_stop":
+                seq = append(seq, fmt.Sprintf("stop:%v", m["index"]))
+            }
+        })
+        return seq
+    }
+
+    // text then a new tool call in a single chunk: text block first, then the tool
+    got := collect("data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Checking.\",\"tool_calls\":[{\"id\":\"call_a\",\"type\":\"function\",\"index\":0,\"function\":{\"name\":\"read\",\"arguments\":\"{\\\"p\\\":1}\"}}]}}]}\n\n" +
+        "data: [DONE]\n\n")
+    want := []string{"start:0:text", "delta:0:Checking.<nil>", "stop:0", "start:1:tool_use", `delta:1:<nil>{"p":1}`, "stop:1"}
+    if strings.Join(got, "|") != strings.Join(want, "|") { t.Fatalf("text+new tool:\n got %v\nwant %v", got, want) }
+
+    // the last fragment of an open tool arrives together with trailing text: keep it on the tool
+    got = collect("data: {\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"id\":\"call_a\",\"type\":\"function\",\"index\":0,\"function\":{\"name\":\"read\",\"arguments\":\"{\\\"p\\\":\"}}]}}]}\n\n" +
+        "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Done.\",\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\"1}\"}}]}}]}\n\n" +
+        "data: [DONE]\n\n")
+    want = []string{"start:0:tool_use", `delta:0:<nil>{"p":`, "delta:0:<nil>1}", "stop:0", "start:1:text", "delta:1:Done.<nil>", "stop:1"}
+    if strings.Join(got, "|") != strings.Join(want, "|") { t.Fatalf("tool fragment+text:\n got %v\nwant %v", got, want) }
+}
+
+func TestOpenAIToAnthropicRequest_EmptyArrayAssistantContent(t *testing.T) {
+    var oreq ad.OpenAIChatRequest
+    raw := `{"model":"gpt-x","messages":[{"role":"user","content":"one"},{"role":"assistant","content":[]},{"role":"user","content":"two"}]}`
+    if err := json.Unmarshal([]byte(raw), &oreq); err != nil { t.Fatalf("unmarshal: %v", err) }
+    areq, err := ad.OpenAIToAnthropicRequest(oreq)
+    if err != nil { t.Fatalf("convert failed: %v", err) }
+    if len(areq.Messages) != 1 || areq.Messages[0].Role != "user" { t.Fatalf("default should drop the turn like an empty string: %#v", areq.Messages) }
+
+    areq, _ = ad.OpenAIToAnthropicRequest(oreq, ad.Options{EmptyAssistantText: "(no response)"})
+    if len(areq.Messages) != 3 || string(areq.Messages[1].Content) != `[{"type":"text","text":"(no response)"}]` { t.Fatalf("placeholder turn: %#v", areq.Messages) }
+    for i, m := range areq.Messages {
+        if string(m.Content) == "[]" || string(m.Content) == "null" { t.Fatalf("message %d has empty content", i) }
+    }
+}
+
+func TestCacheControl_AnthropicRequestRoundTrip(t *testing.T) {
+    in := `{"model":"claude-x","max_tokens":16,` +
+        `"system":[{"type":"text","text":"long rules","cache_control":{"type":"ephemeral"}}],` +
+        `"tools":[{"name":"Read","input_schema":{"type":"object"},"cache_control":{"type":"ephemeral","ttl":"1h"}}],` +
+        `"messages":[{"role":"user","content":[{"type":"text","text":"hi","cache_control":{"type":"ephemeral"}}]}]}`
+    var areq ad.AnthropicMessageRequest
+    if err := json.Unmarshal([]byte(in), &areq); err != nil { t.Fatalf("unmarshal: %v", err) }
+    if cc := areq.Tools[0].CacheControl; cc == nil || cc.Type != "ephemeral" || cc.TTL != "1h" { t.Fatalf("tool cache_control: %+v", cc) }
+    out, _ := json.Marshal(areq)
+    var a, b map[string]interface{}
+    _ = json.Unmarshal([]byte(in), &a)
+    _ = json.Unmarshal(out, &b)
+    if !reflect.DeepEqual(a, b) { t.Fatalf("cache markers lost:\n in: %s\nout: %s", in, out) }
+}
+
+func TestCacheControl_OpenAISystemBlockToAnthropic(t *testing.T) {
+    var oreq ad.OpenAIChatRequest
+    _ = json.Unmarshal([]byte(`{"model":"m","messages":[`+
+        `{"role":"system","content":[{"type":"text","text":"long rules","cache_control":{"type":"ephemeral"}},{"type":"text","text":"today is Monday"}]},`+
+        `{"role":"user","content":[{"type":"text","text":"big doc","cache_control":{"type":"ephemeral"}},{"type":"text","text":"question"}]}],`+
+        `"tools":[{"type":"function","function":{"name":"Read"},"cache_control":{"type":"ephemeral"}}]}`), &oreq)
+    areq, err := ad.OpenAIToAnthropicRequest(oreq)
+    if err != nil { t.Fatalf("convert: %v", err) }
+    var sys []ad.AnthropicContent
+    if err := json.Unmarshal(areq.System, &sys); err != nil { t.Fatalf("system should be a block list: %s", areq.System) }
+    if len(sys) != 2 || sys[0].Text != "long rules" || sys[0].CacheControl == nil || sys[0].CacheControl.Type != "ephemeral" || sys[1].CacheControl != nil { t.Fatalf("system blocks: %s", areq.System) }
+    var parts []ad.AnthropicContent
+    _ = json.Unmarshal(areq.Messages[0].Content, &parts)
+    if len(parts) != 2 || parts[0].CacheControl == nil || parts[1].CacheControl != nil { t.Fatalf("user blocks: %s", areq.Messages[0].Content) }
+    if areq.Tools[0].CacheControl == nil { t.Fatalf("tool cache_control dropped: %+v", areq.Tools[0]) }
+
+    // without markers the system prompt stays a plain string
+    oreq.Messages[0].Content = []interface{}{map[string]interface{}{"type": "text", "text": "a"}, map[string]interface{}{"type": "text", "text": "b"}}
+    areq, _ = ad.OpenAIToAnthropicRequest(oreq)
+    if string(areq.System) != `"a\n\nb"` { t.Fatalf("system: %s", areq.System) }
+}
+
+func TestThinking_ToReasoningContentAndBack(t *testing.T) {
+    stop := "end_turn"
+    aresp := ad.AnthropicMessageResponse{ID: "msg_1", Type: "message", Role: "assistant", StopReason: &stop, Content: []map[string]interface{}{
+        {"type": "thinking", "thinking": "let me add", "signature": "sig123"},
+        {"type": "text", "text": "4"},
+    }}
+    oresp, err := ad.AnthropicToOpenAIResponse(aresp, "gpt-x")
+    if err != nil { t.Fatalf("to OpenAI: %v", err) }
+    msg := oresp.Choices[0].Message
+    if msg.ReasoningContent != "let me add" || msg.ReasoningSignature != "sig123" || msg.Content != "4" { t.Fatalf("message: %+v", msg) }
+
+    // the client sends the turn back on its next request
+    oreq := ad.OpenAIChatRequest{Model: "gpt-x", Messages: []ad.OpenAIMessage{{Role: "user", Content: "2+2?"}, msg, {Role: "user", Content: "and 3+3?"}}}
+    areq, err := ad.OpenAIToAnthropicRequest(oreq)
+    if err != nil { t.Fatalf("to Anthropic: %v", err) }
+    var parts []ad.AnthropicContent
+    _ = json.Unmarshal(areq.Messages[1].Content, &parts)
+    if len(parts) != 2 || parts[0].Type != "thinking" || parts[0].Thinking != "let me add" || parts[0].Signature != "sig123" || parts[1].Text != "4" { t.Fatalf("assistant blocks: %s", areq.Messages[1].Content) }
+}
+
+func TestReasoningContent_ToThinkingBlock(t *testing.T) {
+    var oresp ad.OpenAIChatResponse
+    _ = json.Unmarshal([]byte(`{"id":"c1","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","reasoning_content":"hmm","content":"done"}}]}`), &oresp)
+    aresp, err := ad.OpenAIToAnthropic(oresp, "claude-x")
+    if err != nil { t.Fatalf("convert: %v", err) }
+    if len(aresp.Content) != 2 || aresp.Content[0]["type"] != "thinking" || aresp.Content[0]["thinking"] != "hmm" || aresp.Content[1]["text"] != "done" { t.Fatalf("content: %v", aresp.Content) }
+}
+
+func TestConvertOpenAIStreamToAnthropic_ReasoningBecomesThinkingBlock(t *testing.T) {
+    s := "data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"reasoning_content\":\"Let me \"}}]}\n\n"+
+        "data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"reasoning_content\":\"think\",\"reasoning_signature\":\"sig\"}}]}\n\n"+
+        "data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Answer\"}}]}\n\n"+
+        "data: [DONE]\n\n"
+    var seq []string
+    _ = ad.ConvertOpenAIStreamToAnthropic(context.Background(), "claude-x", strings.NewReader(s), func(ev string, p interface{}) {
+        m, _ := p.(map[string]interface{})
+        switch ev {
+        case "content_block_start":
+            seq = append(seq, fmt.Sprintf("start:%v:%v", m["index"], m["content_block"].(map[string]interface{})["type"]))
+        case "content_block_delta":
+            d := m["delta"].(map[string]interface{})
+            seq = append(seq, fmt.Sprintf("%v:%v:%v%v%v", d["type"], m["index"], nz(d["thinking"]), nz(d["signature"]), nz(d["text"])))
+        case "content_block_stop":
+            seq = append(seq, fmt.Sprintf("stop:%v", m["index"]))
+        }
+    })
+    want := []string{"start:0:thinking", "thinking_delta:0:Let me ", "thinking_delta:0:think", "signature_delta:0:sig", "stop:0", "start:1:text", "text_delta:1:Answer", "stop:1"}
+    if strings.Join(seq, "|") != strings.Join(want, "|") { t.Fatalf("event sequence:\n got %v\nwant %v", seq, want) }
+}
+
+// nz renders a missing map value as "".
+func nz(v interface{}) string { if v == nil { return "" }; return fmt.Sprint(v) }
+
+func TestConvertAnthropicStreamToOpenAI_ThinkingKeepsSignatureAndOrder(t *testing.T) {
+    s := "event: content_block_start\n"+
+        "data: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"thinking\",\"thinking\":\"\"}}\n\n"+
+        "event: content_block_delta\n"+
+        "data: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"thinking_delta\",\"thinking\":\"hmm\"}}\n\n"+
+        "event: content_block_delta\n"+
+        "data: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"signature_delta\",\"signature\":\"sig\"}}\n\n"+
+        "event: content_block_start\n"+
+        "data: {\"type\":\"content_block_start\",\"index\":1,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n"+
+        "event: content_block_delta\n"+
+        "data: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"text_delta\",\"text\":\"4\"}}\n\n"+
+        "event: message_stop\n"+
+        "data: {\"type\":\"message_stop\"}\n\n"
+    var seq []string
+    _ = ad.ConvertAnthropicStreamToOpenAI(context.Background(), "gpt-x", strings.NewReader(s), func(m map[string]interface{}) {
+        d := m["choices"].([]map[string]interface{})[0]["delta"].(map[string]interface{})
+        for _, k := range []string{"reasoning_content", "reasoning_signature", "content"} {
+            if v, ok := d[k]; ok { seq = append(seq, k+"="+fmt.Sprint(v)) }
+        }
+    })
+    want := []string{"reasoning_content=hmm", "reasoning_signature=sig", "content=4"}
+    if strings.Join(seq, "|") != strings.Join(want, "|") { t.Fatalf("deltas:\n got %v\nwant %v", seq, want) }
+}
+
+func TestOpenAIRefusal_MapsToAnthropicText(t *testing.T) {
+    var oresp ad.OpenAIChatResponse
+    _ = json.Unmarshal([]byte(`{"id":"c1","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":null,"refusal":"I can't help with that."}}]}`), &oresp)
+    aresp, err := ad.OpenAIToAnthropic(oresp, "claude-x")
+    if err != nil { t.Fatalf("convert: %v", err) }
+    if len(aresp.Content) != 1 || aresp.Content[0]["type"] != "text" || aresp.Content[0]["text"] != "I can't help with that." { t.Fatalf("content: %v", aresp.Content) }
+    if aresp.StopReason == nil || *aresp.StopReason != "refusal" { t.Fatalf("stop_reason: %v", aresp.StopReason) }
+
+    // sent back as history, the refusal stays visible to the model
+    oreq := ad.OpenAIChatRequest{Model: "m", Messages: []ad.OpenAIMessage{{Role: "user", Content: "x"}, oresp.Choices[0].Message, {Role: "user", Content: "why?"}}}
+    areq, _ := ad.OpenAIToAnthropicRequest(oreq)
+    if !strings.Contains(string(areq.Messages[1].Content), "[refusal] I can't help with that.") { t.Fatalf("assistant history: %s", areq.Messages[1].Content) }
+}
+
+func TestLegacyFunctions_RequestToAnthropic(t *testing.T) {
+    var oreq ad.OpenAIChatRequest
+    err := json.Unmarshal([]byte(`{"model":"m","function_call":{"name":"get_weather"},
+        "functions":[{"name":"get_weather","description":"Weather","parameters":{"type":"object","properties":{"city":{"type":"string"}}}}],
+        "messages":[{"role":"user","content":"weather in Paris?"},
+            {"role":"assistant","content":null,"function_call":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}},
+            {"role":"function","name":"get_weather","content":"sunny"}]}`), &oreq)
+    if err != nil { t.Fatalf("unmarshal: %v", err) }
+    areq, err := ad.OpenAIToAnthropicRequest(oreq)
+    if err != nil { t.Fatalf("convert: %v", err) }
+    if len(areq.Tools) != 1 || areq.Tools[0].Name != "get_weather" || areq.Tools[0].InputSchema["type"] != "object" { t.Fatalf("tools: %+v", areq.Tools) }
+    if tc := areq.ToolChoice; tc == nil || tc.Type != "tool" || tc.Name != "get_weather" { t.Fatalf("tool_choice: %+v", tc) }
+    if _, ok := areq.Extra["function_call"]; ok { t.Fatalf("function_call leaked into extra params") }
+    if len(areq.Messages) != 3 { t.Fatalf("messages: %d", len(areq.Messages)) }
+    var use, result []ad.AnthropicContent
+    _ = json.Unmarshal(areq.Messages[1].Content, &use)
+    _ = json.Unmarshal(areq.Messages[2].Content, &result)
+    if len(use) != 1 || use[0].Type != "tool_use" || use[0].Name != "get_weather" || string(*use[0].Input) != `{"city":"Paris"}` { t.Fatalf("tool_use: %s", areq.Messages[1].Content) }
+    if len(result) != 1 || result[0].Type != "tool_result" || result[0].ToolUseID != use[0].ID || result[0].Content != "sunny" { t.Fatalf("tool_result: %s", areq.Messages[2].Content) }
+
+    oreq.FunctionCall = json.RawMessage(`"none"`)
+    areq, _ = ad.OpenAIToAnthropicRequest(oreq)
+    if areq.ToolChoice == nil || areq.ToolChoice.Type != "none" { t.Fatalf("function_call none: %+v", areq.ToolChoice) }
+}
+
+func TestLegacyFunctions_ResponseShape(t *testing.T) {
+    stop := "tool_use"
+    aresp := ad.AnthropicMessageResponse{ID: "msg_1", StopReason: &stop, Content: []map[string]interface{}{
+        {"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": map[string]interface{}{"city": "Paris"}},
+        {"type": "tool_use", "id": "toolu_2", "name": "get_time", "input": map[string]interface{}{}},
+    }}
+    oresp, err := ad.AnthropicToOpenAIResponse(aresp, "gpt-x", ad.Options{LegacyFunctions: true})
+    if err != nil { t.Fatalf("convert: %v", err) }
+    c := oresp.Choices[0]
+    if c.FinishReason != "function_call" || c.Message.ToolCalls != nil { t.Fatalf("choice: %+v", c) }
+    if fc := c.Message.FunctionCall; fc == nil || fc.Name != "get_weather" || fc.Arguments != `{"city":"Paris"}` { t.Fatalf("function_call: %+v", fc) }
+
+    s := "event: content_block_start\n"+
+        "data: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"tool_use\",\"id\":\"toolu_1\",\"name\":\"get_weather\"}}\n\n"+
+        "event: content_block_delta\n"+
+        "data: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"{}\"}}\n\n"+
+        "event: message_delta\n"+
+        "data: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"tool_use\"}}\n\n"+
+        "event: message_stop\n"+
+        "data: {\"type\":\"message_stop\"}\n\n"
+    var chunks []string
+    _ = ad.ConvertAnthropicStreamToOpenAI(context.Background(), "gpt-x", strings.NewReader(s), func(m map[string]interface{}) {
+        b, _ := json.Marshal(m["choices"])
+        chunks = append(chunks, string(b))
+    }, ad.Options{LegacyFunctions: true})
+    all := strings.Join(chunks, "\n")
+    if strings.Contains(all, "tool_calls") || !strings.Contains(all, `"function_call":{"arguments":"","name":"get_weather"}`) || !strings.Contains(all, `"finish_reason":"function_call"`) { t.Fatalf("stream chunks:\n%s", all) }
+}
+
+func TestOpenAIToAnthropic_ArrayContentKeepsToolCallPosition(t *testing.T) {
+    var oresp ad.OpenAIChatResponse
+    _ = json.Unmarshal([]byte(`{"id":"c1","choices":[{"index":0,"finish_reason":"tool_calls","message":{"role":"assistant",
+        "content":[{"type":"text","text":"Reading the file."},{"type":"tool_call","id":"call_1"},{"type":"text","text":"Then listing."},{"type":"tool_call","id":"call_2"},{"type":"text","text":"Done."}],
+        "tool_calls":[{"id":"call_1","type":"function","function":{"name":"Read","arguments":"{}"}},{"id":"call_2","type":"function","function":{"name":"LS","arguments":"{}"}},{"id":"call_3","type":"function","function":{"name":"Grep","arguments":"{}"}}]}}]}`), &oresp)
+    aresp, err := ad.OpenAIToAnthropic(oresp, "claude-x")
+    if err != nil { t.Fatalf("convert: %v", err) }
+    var seq []string
+    for _, c := range aresp.Content {
+        if c["type"] == "text" { seq = append(seq, "text:"+c["text"].(string)) } else { seq = append(seq, "tool:"+c["name"].(string)) }
+    }
+    want := []string{"text:Reading the file.", "tool:Read", "text:Then listing.", "tool:LS", "text:Done.", "tool:Grep"}
+    if strings.Join(seq, "|") != strings.Join(want, "|") { t.Fatalf("block order:\n got %v\nwant %v", seq, want) }
+}
+
+func TestMessageName_ToolResultsCarryToolName(t *testing.T) {
+    areq := ad.AnthropicMessageRequest{Model: "claude-x", Messages: []ad.AnthropicMsg{
+        {Role: "user", Content: json.RawMessage(`"hi"`)},
+        {Role: "assistant", Content: json.RawMessage(`[{"type":"tool_use","id":"toolu_1","name":"Read","input":{}}]`)},
+        {Role: "user", Content: json.RawMessage(`[{"type":"tool_result","tool_use_id":"toolu_1","content":"ok"}]`)},
+    }}
+    msgs, err := ad.ConvertMessagesToOpenAI(areq)
+    if err != nil { t.Fatalf("convert: %v", err) }
+    if last := msgs[len(msgs)-1]; last.Role != "tool" || last.Name != "Read" { t.Fatalf("tool message: %+v", last) }
+
+    // toward Anthropic the name pairs an id-less result with the call it answers
+    oreq := ad.OpenAIChatRequest{Model: "m", Messages: []ad.OpenAIMessage{
+        {Role: "user", Content: "hi"},
+        {Role: "assistant", ToolCalls: []ad.OpenAIToolCall{{Type: "function", Function: ad.OpenAIToolCallFunction{Name: "Read", Arguments: "{}"}}, {Type: "function", Function: ad.OpenAIToolCallFunction{Name: "LS", Arguments: "{}"}}}},
+        {Role: "tool", Name: "LS", Content: "dir"},
+        {Role: "tool", Name: "Read", Content: "file"},
+    }}
+    out, err := ad.OpenAIToAnthropicRequest(oreq)
+    if err != nil { t.Fatalf("convert: %v", err) }
+    var uses, results []ad.AnthropicContent
+    _ = json.Unmarshal(out.Messages[1].Content, &uses)
+    _ = json.Unmarshal(out.Messages[2].Content, &results)
+    ids := map[string]string{}
+    for _, u := range uses { ids[u.Name] = u.ID }
+    if len(results) != 2 || results[0].ToolUseID != ids["LS"] || results[1].ToolUseID != ids["Read"] { t.Fatalf("results %s for uses %s", out.Messages[2].Content, out.Messages[1].Content) }
+}
+
+func TestAnthropicToOpenAIResponse_StructuredContent(t *testing.T) {
+    blocks := []map[string]interface{}{{"type": "text", "text": "first"}, {"type": "text", "text": "second"}}
+    joined, err := ad.AnthropicToOpenAIResponse(ad.AnthropicMessageResponse{ID: "msg", Content: blocks}, "gpt-x")
+    if err != nil { t.Fatalf("convert: %v", err) }
+    if got := joined.Choices[0].Message.Content; got != "first\n\nsecond" { t.Fatalf("default content: %#v", got) }
+
+    structured, err := ad.AnthropicToOpenAIResponse(ad.AnthropicMessageResponse{ID: "msg", Content: blocks}, "gpt-x", ad.Options{StructuredContent: true})
+    if err != nil { t.Fatalf("convert: %v", err) }
+    b, _ := json.Marshal(structured.Choices[0].Message)
+    var m struct{ Content []struct{ Type, Text string } }
+    if err := json.Unmarshal(b, &m); err != nil { t.Fatalf("content is not an array: %s", b) }
+    if len(m.Content) != 2 || m.Content[0].Type != "text" || m.Content[0].Text != "first" || m.Content[1].Text != "second" { t.Fatalf("structured content: %s", b) }
+}
+
+func TestStopSequenceMapsToStop(t *testing.T) {
+    stop, seq := "stop_sequence", "###"
+    oresp, err := ad.AnthropicToOpenAIResponse(ad.AnthropicMessageResponse{ID: "msg", StopReason: &stop, StopSequence: &seq, Content: []map[string]interface{}{{"type": "text", "text": "done"}}}, "gpt-x")
+    if err != nil { t.Fatalf("convert: %v", err) }
+    if got := oresp.Choices[0].FinishReason; got != "stop" { t.Fatalf("finish_reason = %q", got) }
+
+    sse := "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\",\"usage\":{\"input_tokens\":1}}}\n\n" +
+        "event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"stop_sequence\",\"stop_sequence\":\"###\"},\"usage\":{\"output_tokens\":1}}\n\n" +
+        "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"
+    var finish interface{}
+    err = ad.ConvertAnthropicStreamToOpenAI(context.Background(), "gpt-x", strings.NewReader(sse), func(c map[string]interface{}) {
+        if ch, ok := c["choices"].([]map[string]interface{}); ok && len(ch) > 0 && ch[0]["finish_reason"] != nil { finish = ch[0]["finish_reason"] }
+    })
+    if err != nil { t.Fatalf("stream: %v", err) }
+    if finish != "stop" { t.Fatalf("stream finish_reason = %v", finish) }
+
+    // toward Anthropic a plain stop is end_turn, with stop_sequence present but null
+    a, err := ad.OpenAIToAnthropic(ad.OpenAIChatResponse{Choices: []struct{ Index int `json:"index"`; FinishReason string `json:"finish_reason"`; Message ad.OpenAIMessage `json:"message"` }{{FinishReason: "stop", Message: ad.OpenAIMessage{Role: "assistant", Content: "done"}}}}, "claude-x")
+    if err != nil { t.Fatalf("convert: %v", err) }
+    if a.StopReason == nil || *a.StopReason != "end_turn" { t.Fatalf("stop_reason = %v", a.StopReason) }
+    b, _ := json.Marshal(a)
+    if !strings.Contains(string(b), `"stop_sequence":null`) { t.Fatalf("stop_sequence missing: %s", b) }
+}
+
+func TestStreams_CRLFAndMultiLineData(t *testing.T) {
+    // OpenAI -> Anthropic: CRLF line endings and one chunk split over two data lines
+    openai := "data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\r\n" +
+        "data: \"delta\":{\"content\":\"Hel\"}}]}\r\n\r\n" +
+        "data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"lo\"}}]}\r\n\r\n" +
+        "data: [DONE]\r\n\r\n"
+    var text string
+    err := ad.ConvertOpenAIStreamToAnthropic(context.Background(), "claude-x", strings.NewReader(openai), func(event string, payload interface{}) {
+        if event != "content_block_delta" { return }
+        d := payload.(map[string]interface{})["delta"].(map[string]interface{})
+        if s, ok := d["text"].(string); ok { text += s }
+    })
+    if err != nil || text != "Hello" { t.Fatalf("openai stream text %q, err %v", text, err) }
+
+    // Anthropic -> OpenAI: the same, with a multi-line content_block_delta
+    anthropic := "event: message_start\r\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"m1\",\"usage\":{\"input_tokens\":1}}}\r\n\r\n" +
+        "event: content_block_start\r\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\r\n\r\n" +
+        "event: content_block_delta\r\ndata: {\"type\":\"content_block_delta\",\"index\":0,\r\ndata: \"delta\":{\"type\":\"text_delta\",\"text\":\"Hi there\"}}\r\n\r\n" +
+        "event: content_block_stop\r\ndata: {\"type\":\"content_block_stop\",\"index\":0}\r\n\r\n" +
+        "event: message_delta\r\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\"},\"usage\":{\"output_tokens\":2}}\r\n\r\n" +
+        "event: message_stop\r\ndata: {\"type\":\"message_stop\"}\r\n\r\n"
+    text = ""
+    finished := false
+    err = ad.ConvertAnthropicStreamToOpenAI(context.Background(), "gpt-x", strings.NewReader(anthropic), func(c map[string]interface{}) {
+        ch, ok := c["choices"].([]map[string]interface{})
+        if !ok || len(ch) == 0 { return }
+        if d, ok := ch[0]["delta"].(map[string]interface{}); ok { if s, ok := d["content"].(string); ok { text += s } }
+        if ch[0]["finish_reason"] == "stop" { finished = true }
+    })
+    if err != nil || text != "Hi there" || !finished { t.Fatalf("anthropic stream text %q, finished %v, err %v", text, finished, err) }
+}
+
+func TestConvertAnthropicStreamToOpenAI_DataFirstAndEventless(t *testing.T) {
+    // data before event, then frames with no event line at all
+    sse := "data: {\"type\":\"message_start\",\"message\":{\"id\":\"m1\",\"usage\":{\"input_tokens\":3}}}\nevent: message_start\n\n" +
+        "data: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n" +
+        "data: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hi\"}}\nevent: content_block_delta\n\n" +
+        "data: {\"type\":\"content_block_stop\",\"index\":0}\n\n" +
+        "data: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"max_tokens\"},\"usage\":{\"output_tokens\":1}}\n\n" +
+        "data: {\"type\":\"message_stop\"}\n\n"
+    var text string
+    var finish interface{}
+    err := ad.ConvertAnthropicStreamToOpenAI(context.Background(), "gpt-x", strings.NewReader(sse), func(c map[string]interface{}) {
+        ch, ok := c["choices"].([]map[string]interface{})
+        if !ok || len(ch) == 0 { return }
+        if d, ok := ch[0]["delta"].(map[string]interface{}); ok { if s, ok := d["content"].(string); ok { text += s } }
+        if f := ch[0]["finish_reason"]; f != nil { finish = f }
+    }, ad.Options{IncludeUsage: true})
+    if err != nil || text != "Hi" || finish != "length" { t.Fatalf("text %q, finish %v, err %v", text, finish, err) }
+}
+
+// streamBlocks runs an OpenAI stream through ConvertOpenAIStreamToAnthropic and
+// returns its content blocks in order as "text:<text>" or "tool_use:<name>:<args>".
+func streamBlocks(t *testing.T, chunks ...string) []string {
+    t.Helper()
+    var sse strings.Builder
+    for _, c := range chunks { sse.WriteString("data: " + c + "\n\n") }
+    sse.WriteString("data: [DONE]\n\n")
+    var blocks []string
+    open := -1
+    err := ad.ConvertOpenAIStreamToAnthropic(context.Background(), "claude-x", strings.NewReader(sse.String()), func(event string, payload interface{}) {
+        p, _ := payload.(map[string]interface{})
+        switch event {
+        case "content_block_start":
+            if open >= 0 { t.Fatalf("block %d started while %d is open", p["index"], open) }
+            open = p["index"].(int)
+            cb := p["content_block"].(map[string]interface{})
+            if cb["type"] == "tool_use" { blocks = append(blocks, "tool_use:"+cb["name"].(string)+":") } else { blocks = append(blocks, cb["type"].(string)+":") }
+        case "content_block_delta":
+            d := p["delta"].(map[string]interface{})
+            for _, k := range []string{"text", "partial_json"} { if s, ok := d[k].(string); ok { blocks[len(blocks)-1] += s } }
+        case "content_block_stop":
+            open = -1
+        }
+    })
+    if err != nil { t.Fatalf("stream: %v", err) }
+    return blocks
+}
+
+func TestConvertOpenAIStreamToAnthropic_ContentThenToolOrdering(t *testing.T) {
+    got := streamBlocks(t,
+        `{"id":"c1","choices":[{"index":0,"delta":{"content":"Let me "}}]}`,
+        `{"id":"c1","choices":[{"index":0,"delta":{"content":"check."}}]}`,
+        `{"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"Read","arguments":"{\"path\":"}}]}}]}`,
+        `{"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"a\"}"}}]}}]}`,
+        // text and the next call's start in one chunk: the text comes first
+        `{"id":"c1","choices":[{"index":0,"delta":{"content":"And ","tool_calls":[{"index":1,"id":"call_2","type":"function","function":{"name":"LS","arguments":"{}"}}]}}]}`,
+    )
+    want := []string{"text:Let me check.", `tool_use:Read:{"path":"a"}`, "text:And ", "tool_use:LS:{}"}
+    if fmt.Sprint(got) != fmt.Sprint(want) { t.Fatalf("blocks\n got %q\nwant %q", got, want) }
+
+    // a call announced by id alone waits for its name even when text arrives meanwhile
+    got = streamBlocks(t,
+        `{"id":"c1","choices":[{"index":0,"delta":{"content":"Before."}}]}`,
+        `{"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"arguments":""}}]}}]}`,
+        `{"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"name":"Read","arguments":"{}"}}]}}]}`,
+    )
+    want = []string{"text:Before.", "tool_use:Read:{}"}
+    if fmt.Sprint(got) != fmt.Sprint(want) { t.Fatalf("blocks\n got %q\nwant %q", got, want) }
+
+    // text between a call's id and its name doesn't force the call out nameless
+    got = streamBlocks(t,
+        `{"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"arguments":""}}]}}]}`,
+        `{"id":"c1","choices":[{"index":0,"delta":{"content":"Meanwhile."}}]}`,
+        `{"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"name":"Read","arguments":"{}"}}]}}]}`,
+    )
+    want = []string{"text:Meanwhile.", "tool_use:Read:{}"}
+    if fmt.Sprint(got) != fmt.Sprint(want) { t.Fatalf("blocks\n got %q\nwant %q", got, want) }
+}
+
+func TestConvertOpenAIStreamToAnthropic_DuplicateIndexNewID(t *testing.T) {
+    got := streamBlocks(t,
+        `{"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"Read","arguments":"{\"path\":"}}]}}]}`,
+        `{"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"a\"}"}}]}}]}`,
+        // a second call reusing index 0 with its own id
+        `{"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_2","type":"function","function":{"name":"LS","arguments":"{\"dir\":"}}]}}]}`,
+        `{"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"b\"}"}}]}}]}`,
+    )
+    want := []string{`tool_use:Read:{"path":"a"}`, `tool_use:LS:{"dir":"b"}`}
+    if fmt.Sprint(got) != fmt.Sprint(want) { t.Fatalf("blocks\n got %q\nwant %q", got, want) }
+
+    // the same id repeated at its index is still one call
+    got = streamBlocks(t,
+        `{"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"Read","arguments":"{"}}]}}]}`,
+        `{"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","function":{"arguments":"}"}}]}}]}`,
+    )
+    if fmt.Sprint(got) != fmt.Sprint([]string{"tool_use:Read:{}"}) { t.Fatalf("repeated id split the call: %q", got) }
+}
+
+func TestStreams_OverLongLineEndsStream(t *testing.T) {
+    long := strings.Repeat("x", 4096)
+    openai := "data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"ok\"}}]}\n\n" +
+        "data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"" + long + "\"}}]}\n\n" +
+        "data: [DONE]\n\n"
+    var events []string
+    err := ad.ConvertOpenAIStreamToAnthropic(context.Background(), "claude-x", strings.NewReader(openai), func(event string, payload interface{}) { events = append(events, event) }, ad.Options{MaxSSELine: 1024})
+    if !errors.Is(err, ad.ErrSSELineTooLong) { t.Fatalf("err = %v", err) }
+    if len(events) == 0 || events[len(events)-1] != "error" { t.Fatalf("events %v: want a final error event", events) }
+
+    anthropic := "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"m1\"}}\n\n" +
+        "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"" + long + "\"}}\n\n"
+    var last map[string]interface{}
+    err = ad.ConvertAnthropicStreamToOpenAI(context.Background(), "gpt-x", strings.NewReader(anthropic), func(c map[string]interface{}) { last = c }, ad.Options{MaxSSELine: 1024})
+    if !errors.Is(err, ad.ErrSSELineTooLong) || last["error"] == nil { t.Fatalf("err = %v, last chunk %v", err, last) }
+
+    // many short data lines add up to the limit too
+    multi := "data: {\"id\":\"c1\",\n" + strings.Repeat("data: \"pad\":1,\n", 200) + "\n"
+    err = ad.ConvertOpenAIStreamToAnthropic(context.Background(), "claude-x", strings.NewReader(multi), func(string, interface{}) {}, ad.Options{MaxSSELine: 1024})
+    if !errors.Is(err, ad.ErrSSELineTooLong) { t.Fatalf("multi-line err = %v", err) }
+}
+
+func TestOpenAIToAnthropicRequest_ChatRequestFields(t *testing.T) {
+    convert := func(body string) ad.AnthropicMessageRequest {
+        t.Helper()
+        var oreq ad.OpenAIChatRequest
+        if err := json.Unmarshal([]byte(body), &oreq); err != nil { t.Fatal(err) }
+        areq, err := ad.OpenAIToAnthropicRequest(oreq)
+        if err != nil { t.Fatal(err) }
+        if _, ok := areq.Extra["tool_choice"]; ok { t.Fatalf("tool_choice left in Extra: %v", areq.Extra) }
+        return areq
+    }
+    const msgs = `"messages":[{"role":"user","content":"hi"}]`
+    choices := []struct{ tc string; want ad.AnthropicToolChoice }{
+        {`"required"`, ad.AnthropicToolChoice{Type: "any"}},
+        {`"none"`, ad.AnthropicToolChoice{Type: "none"}},
+        {`{"type":"function","function":{"name":"lookup"}}`, ad.AnthropicToolChoice{Type: "tool", Name: "lookup"}},
+    }
+    for _, c := range choices {
+        areq := convert(`{"model":"gpt-x","tool_choice":` + c.tc + `,` + msgs + `}`)
+        if areq.ToolChoice == nil || *areq.ToolChoice != c.want { t.Fatalf("tool_choice %s -> %+v, want %+v", c.tc, areq.ToolChoice, c.want) }
+    }
+    if areq := convert(`{"model":"gpt-x","tool_choice":"auto",` + msgs + `}`); areq.ToolChoice != nil { t.Fatalf("auto should stay Anthropic's default: %+v", areq.ToolChoice) }
+    areq := convert(`{"model":"gpt-x","tool_choice":"required","parallel_tool_calls":false,` + msgs + `}`)
+    if areq.ToolChoice == nil || *areq.ToolChoice != (ad.AnthropicToolChoice{Type: "any", DisableParallelToolUse: true}) { t.Fatalf("required without parallel calls: %+v", areq.ToolChoice) }
+
+    if areq := convert(`{"model":"gpt-x","max_completion_tokens":300,` + msgs + `}`); areq.MaxTokens != 300 || areq.Extra["max_completion_tokens"] != nil { t.Fatalf("max_completion_tokens: %d %v", areq.MaxTokens, areq.Extra) }
+    if areq := convert(`{"model":"gpt-x","max_tokens":100,"max_completion_tokens":300,` + msgs + `}`); areq.MaxTokens != 100 { t.Fatalf("max_tokens should win: %d", areq.MaxTokens) }
+
+    if areq := convert(`{"model":"gpt-x","user":"u-42",` + msgs + `}`); string(areq.Metadata) != `{"user_id":"u-42"}` || areq.Extra["user"] != nil { t.Fatalf("user: %s %v", areq.Metadata, areq.Extra) }
+    if areq := convert(`{"model":"gpt-x","user":"u-42","metadata":{"user_id":"m-1"},` + msgs + `}`); string(areq.Metadata) != `{"user_id":"m-1"}` { t.Fatalf("metadata.user_id should win: %s", areq.Metadata) }
+}
+
+func TestLegacyFunctions_OneCallPerTurn(t *testing.T) {
+    oreq := ad.OpenAIChatRequest{Model: "m", Functions: []ad.OpenAIFunction{{Name: "get_weather"}}, Messages: []ad.OpenAIMessage{{Role: "user", Content: "hi"}}}
+    areq, _ := ad.OpenAIToAnthropicRequest(oreq)
+    if tc := areq.ToolChoice; tc == nil || tc.Type != "auto" || !tc.DisableParallelToolUse { t.Fatalf("functions request tool_choice: %+v", tc) }
+    oreq.FunctionCall = json.RawMessage(`{"name":"get_weather"}`)
+    areq, _ = ad.OpenAIToAnthropicRequest(oreq)
+    if tc := areq.ToolChoice; tc == nil || tc.Type != "tool" || !tc.DisableParallelToolUse { t.Fatalf("named function_call tool_choice: %+v", tc) }
+    oreq.FunctionCall = json.RawMessage(`"none"`)
+    areq, _ = ad.OpenAIToAnthropicRequest(oreq)
+    if tc := areq.ToolChoice; tc == nil || tc.Type != "none" || tc.DisableParallelToolUse { t.Fatalf("function_call none tool_choice: %+v", tc) }
+    oreq = ad.OpenAIChatRequest{Model: "m", Tools: []ad.OpenAITool{{Type: "function", Function: ad.OpenAIFunction{Name: "get_weather"}}}, Messages: oreq.Messages}
+    if areq, _ = ad.OpenAIToAnthropicRequest(oreq); areq.ToolChoice != nil { t.Fatalf("tools request tool_choice: %+v", areq.ToolChoice) }
+
+    var warned []string
+    opts := ad.Options{LegacyFunctions: true, Warn: func(kind, detail string) { warned = append(warned, kind) }}
+    stop := "tool_use"
+    aresp := ad.AnthropicMessageResponse{ID: "msg_1", StopReason: &stop, Content: []map[string]interface{}{
+        {"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": map[string]interface{}{}},
+        {"type": "tool_use", "id": "toolu_2", "name": "get_time", "input": map[string]interface{}{}},
+    }}
+    if _, err := ad.AnthropicToOpenAIResponse(aresp, "gpt-x", opts); err != nil { t.Fatal(err) }
+    if fmt.Sprint(warned) != "[function_calls_dropped]" { t.Fatalf("non-stream warnings: %v", warned) }
+
+    warned = nil
+    s := "event: content_block_start\n"+
+        "data: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"tool_use\",\"id\":\"toolu_1\",\"name\":\"get_weather\"}}\n\n"+
+        "event: content_block_start\n"+
+        "data: {\"type\":\"content_block_start\",\"index\":1,\"content_block\":{\"type\":\"tool_use\",\"id\":\"toolu_2\",\"name\":\"get_time\"}}\n\n"+
+        "event: message_stop\n"+
+        "data: {\"type\":\"message_stop\"}\n\n"
+    _ = ad.ConvertAnthropicStreamToOpenAI(context.Background(), "gpt-x", strings.NewReader(s), func(map[string]interface{}) {}, opts)
+    if fmt.Sprint(warned) != "[function_calls_dropped]" { t.Fatalf("stream warnings: %v", warned) }
+}
+
+func TestOpenAIToAnthropic_StopReasonVocabulary(t *testing.T) {
+    for finish, want := range map[string]string{"stop": "end_turn", "length": "max_tokens", "tool_calls": "tool_use", "content_filter": "refusal", "eos": "end_turn"} {
+        var oresp ad.OpenAIChatResponse
+        _ = json.Unmarshal([]byte(`{"id":"c1","choices":[{"index":0,"finish_reason":"`+finish+`","message":{"role":"assistant","content":"ok"}}]}`), &oresp)
+        aresp, err := ad.OpenAIToAnthropic(oresp, "claude-x")
+        if err != nil { t.Fatalf("convert: %v", err) }
+        if aresp.StopReason == nil || *aresp.StopReason != want { t.Fatalf("finish_reason %q -> %v, want %s", finish, aresp.StopReason, want) }
+    }
+}
+
+func TestAnthropicToOpenAI_BuiltinTools(t *testing.T) {
+    var warned []string
+    opts := ad.Options{Warn: func(kind, detail string) { warned = append(warned, kind+": "+detail) }}
+    tools := []ad.AnthropicTool{{Type: "bash_20250124", Name: "bash"}, {Type: "web_search_20250305", Name: "web_search"}, {Name: "lookup", InputSchema: map[string]interface{}{"type": "object"}}}
+    oreq, err := ad.AnthropicToOpenAI(ad.AnthropicMessageRequest{Model: "claude-x", Tools: tools, Messages: []ad.AnthropicMsg{{Role: "user", Content: json.RawMessage(`"hi"`)}}}, opts)
+    if err != nil { t.Fatalf("convert: %v", err) }
+    if len(oreq.Tools) != 2 || oreq.Tools[0].Function.Name != "bash" || oreq.Tools[1].Function.Name != "lookup" { t.Fatalf("tools: %+v", oreq.Tools) }
+    for _, tool := range oreq.Tools {
+        if tool.Type != "function" { t.Fatalf("tool %s sent with type %q", tool.Function.Name, tool.Type) }
+    }
+    // the built-in tool carries its documented schema rather than none
+    props, _ := oreq.Tools[0].Function.Parameters["properties"].(map[string]interface{})
+    if _, ok := props["command"]; !ok { t.Fatalf("bash schema: %+v", oreq.Tools[0].Function.Parameters) }
+    if len(warned) != 2 || !strings.HasPrefix(warned[0], "builtin_tool: bash_20250124") || !strings.Contains(warned[1], "web_search_20250305") || !strings.Contains(warned[1], "dropped") { t.Fatalf("warnings: %v", warned) }
+}
+
+func TestConvertOpenAIStreamToAnthropic_InterleavedToolIndices(t *testing.T) {
+    got := streamBlocks(t,
+        `{"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"name":"A","arguments":"{\"x\":"}}]}}]}`,
+        `{"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"call_b","type":"function","function":{"name":"B","arguments":"{\"y\":"}}]}}]}`,
+        `{"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"1}"}}]}}]}`,
+        `{"id":"c1","choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"function":{"arguments":"2}"}}]}}]}`,
+    )
+    want := []string{`tool_use:A:{"x":1}`, `tool_use:B:{"y":2}`}
+    if fmt.Sprint(got) != fmt.Sprint(want) { t.Fatalf("blocks\n got %q\nwant %q", got, want) }
+    for _, b := range got {
+        if args := b[strings.LastIndex(b, ":{")+1:]; !json.Valid([]byte(args)) { t.Fatalf("tool input %q is not valid JSON", args) }
+    }
+}
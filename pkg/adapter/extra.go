@@ -0,0 +1,117 @@
+package adapter
+
+import (
+    "bytes"
+    "encoding/json"
+    "reflect"
+    "sort"
+    "strings"
+)
+
+// Request fields the structs don't model are kept in Extra so that new upstream
+// parameters (service_tier, vendor extensions, ...) reach the target API without
+// a code change for each one. Parameters known to exist only in the source API
+// are dropped during conversion instead, since the target would reject them.
+var (
+    anthropicOnlyParams = []string{"thinking", "container", "mcp_servers"}
+    openAIOnlyParams    = []string{"logprobs", "top_logprobs", "logit_bias", "store", "modalities", "audio", "prediction", "reasoning_effort"}
+)
+
+type anthropicRequestFields AnthropicMessageRequest
+type openAIRequestFields OpenAIChatRequest
+
+var (
+    anthropicRequestKeys = jsonKeys(reflect.TypeOf(anthropicRequestFields{}))
+    openAIRequestKeys    = jsonKeys(reflect.TypeOf(openAIRequestFields{}))
+)
+
+func (r *AnthropicMessageRequest) UnmarshalJSON(b []byte) error {
+    var f anthropicRequestFields
+    if err := json.Unmarshal(b, &f); err != nil { return err }
+    extra, err := unmodeledFields(b, anthropicRequestKeys)
+    if err != nil { return err }
+    f.Extra = extra
+    *r = AnthropicMessageRequest(f)
+    return nil
+}
+
+func (r AnthropicMessageRequest) MarshalJSON() ([]byte, error) {
+    b, err := json.Marshal(anthropicRequestFields(r))
+    if err != nil { return nil, err }
+    return appendFields(b, r.Extra, anthropicRequestKeys), nil
+}
+
+func (r *OpenAIChatRequest) UnmarshalJSON(b []byte) error {
+    var f openAIRequestFields
+    if err := json.Unmarshal(b, &f); err != nil { return err }
+    extra, err := unmodeledFields(b, openAIRequestKeys)
+    if err != nil { return err }
+    f.Extra = extra
+    *r = OpenAIChatRequest(f)
+    return nil
+}
+
+func (r OpenAIChatRequest) MarshalJSON() ([]byte, error) {
+    b, err := json.Marshal(openAIRequestFields(r))
+    if err != nil { return nil, err }
+    return appendFields(b, r.Extra, openAIRequestKeys), nil
+}
+
+// jsonKeys lists the JSON names of a struct's fields.
+func jsonKeys(t reflect.Type) map[string]bool {
+    keys := map[string]bool{}
+    for i := 0; i < t.NumField(); i++ {
+        name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+        if name != "" && name != "-" { keys[name] = true }
+    }
+    return keys
+}
+
+// unmodeledFields returns the top-level keys of b that aren't in known, or nil.
+func unmodeledFields(b []byte, known map[string]bool) (map[string]json.RawMessage, error) {
+    var all map[string]json.RawMessage
+    if err := json.Unmarshal(b, &all); err != nil { return nil, err }
+    for k := range all {
+        if known[k] { delete(all, k) }
+    }
+    if len(all) == 0 { return nil, nil }
+    return all, nil
+}
+
+// appendFields adds extra to the JSON object b in key order, skipping keys the
+// struct models itself so an extra can never shadow a converted field.
+func appendFields(b []byte, extra map[string]json.RawMessage, known map[string]bool) []byte {
+    if len(extra) == 0 { return b }
+    keys := make([]string, 0, len(extra))
+    for k := range extra {
+        if !known[k] { keys = append(keys, k) }
+    }
+    if len(keys) == 0 { return b }
+    sort.Strings(keys)
+    var buf bytes.Buffer
+    buf.Write(bytes.TrimSuffix(bytes.TrimSpace(b), []byte("}")))
+    for _, k := range keys {
+        if buf.Len() > 1 { buf.WriteByte(',') }
+        kb, _ := json.Marshal(k)
+        buf.Write(kb)
+        buf.WriteByte(':')
+        buf.Write(extra[k])
+    }
+    buf.WriteByte('}')
+    return buf.Bytes()
+}
+
+// forwardExtra copies extra for the other API, dropping the source API's own parameters.
+func (o Options) forwardExtra(extra map[string]json.RawMessage, sourceOnly []string, target string) map[string]json.RawMessage {
+    if len(extra) == 0 { return nil }
+    out := make(map[string]json.RawMessage, len(extra))
+    for k, v := range extra { out[k] = v }
+    for _, k := range sourceOnly {
+        if _, ok := out[k]; ok {
+            delete(out, k)
+            o.warn("unsupported_param", "%s dropped: %s has no equivalent", k, target)
+        }
+    }
+    if len(out) == 0 { return nil }
+    return out
+}
@@ -0,0 +1,86 @@
+package adapter
+
+import (
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strconv"
+)
+
+// OpenAI metadata limits: string values only, at most 16 pairs.
+const (
+    openAIMetadataMaxKeys     = 16
+    openAIMetadataMaxKeyLen   = 64
+    openAIMetadataMaxValueLen = 512
+)
+
+// flattenMetadata converts Anthropic request metadata into OpenAI's string->string
+// map. Nested objects become dotted keys ({"a":{"b":1}} -> "a.b":"1"), arrays keep
+// their JSON text, and null values are skipped. Pairs over OpenAI's limits are
+// dropped with a warning.
+func (o Options) flattenMetadata(raw json.RawMessage) map[string]string {
+    if len(raw) == 0 || string(raw) == "null" { return nil }
+    var obj map[string]interface{}
+    if err := json.Unmarshal(raw, &obj); err != nil {
+        o.warn("metadata_dropped", "metadata is not an object; dropped")
+        return nil
+    }
+    flat := map[string]string{}
+    flattenInto(flat, "", obj)
+    keys := make([]string, 0, len(flat))
+    for k := range flat { keys = append(keys, k) }
+    sort.Strings(keys)
+    out := map[string]string{}
+    for _, k := range keys {
+        v := flat[k]
+        switch {
+        case len(k) > openAIMetadataMaxKeyLen:
+            o.warn("metadata_dropped", "metadata key %q longer than %d characters dropped", k, openAIMetadataMaxKeyLen)
+        case len(v) > openAIMetadataMaxValueLen:
+            o.warn("metadata_dropped", "metadata %q value longer than %d characters dropped", k, openAIMetadataMaxValueLen)
+        case len(out) >= openAIMetadataMaxKeys:
+            o.warn("metadata_dropped", "metadata %q beyond %d keys dropped", k, openAIMetadataMaxKeys)
+        default:
+            out[k] = v
+        }
+    }
+    if len(out) == 0 { return nil }
+    return out
+}
+
+func flattenInto(out map[string]string, prefix string, v interface{}) {
+    switch t := v.(type) {
+    case map[string]interface{}:
+        for k, val := range t {
+            key := k
+            if prefix != "" { key = prefix + "." + k }
+            flattenInto(out, key, val)
+        }
+    case nil:
+    case string:
+        out[prefix] = t
+    case float64:
+        out[prefix] = strconv.FormatFloat(t, 'f', -1, 64)
+    case bool:
+        out[prefix] = strconv.FormatBool(t)
+    default:
+        b, err := json.Marshal(t)
+        if err != nil { b = []byte(fmt.Sprint(t)) }
+        out[prefix] = string(b)
+    }
+}
+
+// anthropicMetadata keeps the one metadata key Anthropic accepts, user_id,
+// falling back to the request's user field.
+func (o Options) anthropicMetadata(md map[string]string, user string) json.RawMessage {
+    var dropped int
+    for k := range md {
+        if k != "user_id" { dropped++ }
+    }
+    if dropped > 0 { o.warn("metadata_dropped", "%d metadata keys dropped: Anthropic accepts only user_id", dropped) }
+    uid, ok := md["user_id"]
+    if !ok && user != "" { uid, ok = user, true }
+    if !ok { return nil }
+    b, _ := json.Marshal(map[string]string{"user_id": uid})
+    return b
+}
@@ -0,0 +1,97 @@
+package adapter
+
+import (
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// RoundTripEqual converts areq to OpenAI and back and reports whether the result
+// is structurally equal to the original. Representation-only differences are
+// ignored (string vs single text block content, a string vs block-list system
+// prompt); anything else is listed in diff, one "path: before != after" per line.
+// It is meant for testing conversation histories against the adapter.
+func RoundTripEqual(areq AnthropicMessageRequest) (bool, string) {
+    oreq, err := AnthropicToOpenAI(areq)
+    if err != nil { return false, "to OpenAI: " + err.Error() }
+    back, err := OpenAIToAnthropicRequest(oreq)
+    if err != nil { return false, "back to Anthropic: " + err.Error() }
+    var diffs []string
+    diffValues("", canonicalRequest(areq), canonicalRequest(back), &diffs)
+    return len(diffs) == 0, strings.Join(diffs, "\n")
+}
+
+// canonicalRequest renders a request as generic JSON with content normalized to block lists.
+func canonicalRequest(r AnthropicMessageRequest) interface{} {
+    b, _ := json.Marshal(r)
+    var v map[string]interface{}
+    _ = json.Unmarshal(b, &v)
+    if s, ok := v["system"]; ok { v["system"] = canonicalContent(s) }
+    if msgs, ok := v["messages"].([]interface{}); ok {
+        for _, m := range msgs {
+            if mp, ok := m.(map[string]interface{}); ok { mp["content"] = canonicalContent(mp["content"]) }
+        }
+    }
+    return v
+}
+
+// canonicalContent turns string content into a single text block and joins
+// adjacent text blocks, mirroring how the conversion itself concatenates them.
+func canonicalContent(c interface{}) interface{} {
+    if s, ok := c.(string); ok { c = []interface{}{map[string]interface{}{"type": "text", "text": s}} }
+    arr, ok := c.([]interface{})
+    if !ok { return c }
+    var out []interface{}
+    for _, it := range arr {
+        mp, ok := it.(map[string]interface{})
+        if ok && mp["type"] == "tool_result" { mp["content"] = canonicalToolResult(mp["content"]) }
+        if ok && mp["type"] == "text" && len(out) > 0 {
+            if prev, ok := out[len(out)-1].(map[string]interface{}); ok && prev["type"] == "text" && len(prev) == 2 && len(mp) == 2 {
+                prev["text"] = fmt.Sprint(prev["text"]) + "\n\n" + fmt.Sprint(mp["text"])
+                continue
+            }
+        }
+        out = append(out, it)
+    }
+    return out
+}
+
+// canonicalToolResult treats a tool_result made of one text block like its string form.
+func canonicalToolResult(c interface{}) interface{} {
+    if arr, ok := c.([]interface{}); ok && len(arr) == 1 {
+        if mp, ok := arr[0].(map[string]interface{}); ok && mp["type"] == "text" && len(mp) == 2 { return mp["text"] }
+    }
+    return c
+}
+
+func diffValues(path string, a, b interface{}, out *[]string) {
+    switch av := a.(type) {
+    case map[string]interface{}:
+        bv, ok := b.(map[string]interface{})
+        if !ok { break }
+        keys := map[string]bool{}
+        for k := range av { keys[k] = true }
+        for k := range bv { keys[k] = true }
+        sorted := make([]string, 0, len(keys))
+        for k := range keys { sorted = append(sorted, k) }
+        sort.Strings(sorted)
+        for _, k := range sorted { diffValues(path+"."+k, av[k], bv[k], out) }
+        return
+    case []interface{}:
+        bv, ok := b.([]interface{})
+        if !ok { break }
+        n := len(av)
+        if len(bv) > n { n = len(bv) }
+        for i := 0; i < n; i++ {
+            var x, y interface{}
+            if i < len(av) { x = av[i] }
+            if i < len(bv) { y = bv[i] }
+            diffValues(fmt.Sprintf("%s[%d]", path, i), x, y, out)
+        }
+        return
+    }
+    ja, _ := json.Marshal(a)
+    jb, _ := json.Marshal(b)
+    if string(ja) != string(jb) { *out = append(*out, fmt.Sprintf("%s: %s != %s", strings.TrimPrefix(path, "."), ja, jb)) }
+}
@@ -0,0 +1,110 @@
+package adapter
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "io"
+    "strings"
+)
+
+// DefaultSSEMaxLine is the longest SSE line, or event data, the stream
+// converters accept when Options.MaxSSELine is unset.
+const DefaultSSEMaxLine = 10 << 20
+
+// ErrSSELineTooLong ends a stream whose upstream sent a line, or an event's
+// data, longer than the limit.
+var ErrSSELineTooLong = errors.New("upstream SSE line exceeds the size limit")
+
+// sseEvent is one server-sent event: its event name, if any, and its data
+// lines joined with "\n".
+type sseEvent struct{ name, data string }
+
+// sseReader parses a server-sent event stream as the spec describes: lines end
+// in "\n" or "\r\n", an event's fields accumulate in any order until a blank
+// line, and repeated data lines are joined with "\n". Comments and unknown
+// fields are skipped. Upstreams that leave out the blank line are tolerated: a
+// second event line, or a data line after data that is already complete JSON,
+// starts a new event.
+type sseReader struct {
+    r       *bufio.Reader
+    max     int
+    pending sseEvent
+    hasData bool
+}
+
+// newSSEReader reads events from r, failing with ErrSSELineTooLong once a line
+// or an event's data passes max bytes (<= 0 uses DefaultSSEMaxLine).
+func newSSEReader(r io.Reader, max int) *sseReader {
+    if max <= 0 { max = DefaultSSEMaxLine }
+    return &sseReader{r: bufio.NewReader(r), max: max}
+}
+
+// next returns the next event with data, or the read error (io.EOF at the end
+// of the stream). An event still open when the stream ends is returned first.
+func (s *sseReader) next() (sseEvent, error) {
+    for {
+        line, err := s.readLine()
+        if errors.Is(err, ErrSSELineTooLong) { return sseEvent{}, err }
+        if line != "" {
+            line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+            ev, ok := s.field(line)
+            if len(s.pending.data) > s.max { return sseEvent{}, ErrSSELineTooLong }
+            if ok { return ev, nil }
+        }
+        if err != nil {
+            if s.hasData { return s.take(), nil }
+            if errors.Is(err, io.EOF) { return sseEvent{}, io.EOF }
+            return sseEvent{}, err
+        }
+    }
+}
+
+// readLine reads through the next "\n" without holding more than max bytes.
+func (s *sseReader) readLine() (string, error) {
+    var line []byte
+    for {
+        frag, err := s.r.ReadSlice('\n')
+        if len(line)+len(frag) > s.max { return "", ErrSSELineTooLong }
+        line = append(line, frag...)
+        if err != bufio.ErrBufferFull { return string(line), err }
+    }
+}
+
+// field applies one line to the pending event, reporting the event it completes, if any.
+func (s *sseReader) field(line string) (sseEvent, bool) {
+    if line == "" {
+        if s.hasData { return s.take(), true }
+        s.pending = sseEvent{}
+        return sseEvent{}, false
+    }
+    if line[0] == ':' { return sseEvent{}, false }
+    name, value, _ := strings.Cut(line, ":")
+    value = strings.TrimPrefix(value, " ")
+    var done sseEvent
+    ok := false
+    switch name {
+    case "event":
+        if s.hasData && s.pending.name != "" { done, ok = s.take(), true }
+        s.pending.name = strings.TrimSpace(value)
+    case "data":
+        if s.hasData && json.Valid([]byte(s.pending.data)) { done, ok = s.take(), true }
+        if s.hasData { s.pending.data += "\n" + value } else { s.pending.data, s.hasData = value, true }
+    }
+    return done, ok
+}
+
+// eventType returns the event's name, or for an event-less frame the "type"
+// field of its JSON data, as Anthropic payloads repeat the event name there.
+func (e sseEvent) eventType() string {
+    if e.name != "" { return e.name }
+    var v struct{ Type string `json:"type"` }
+    _ = json.Unmarshal([]byte(e.data), &v)
+    return v.Type
+}
+
+func (s *sseReader) take() sseEvent {
+    ev := s.pending
+    s.pending, s.hasData = sseEvent{}, false
+    return ev
+}
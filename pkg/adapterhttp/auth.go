@@ -0,0 +1,41 @@
+package adapterhttp
+
+import (
+    "crypto/sha256"
+    "crypto/subtle"
+    "net/http"
+    "strings"
+)
+
+// Auth requires one of tokens on /v1/* requests, sent as "Authorization: Bearer
+// <token>" or "x-api-key: <token>"; other paths such as /health stay open. An
+// empty tokens list returns next unchanged. Rejections are 401s in the error
+// shape of the API the path belongs to.
+func Auth(tokens []string, next http.Handler) http.Handler {
+    var sums [][sha256.Size]byte
+    for _, t := range tokens {
+        if t = strings.TrimSpace(t); t != "" { sums = append(sums, sha256.Sum256([]byte(t))) }
+    }
+    if len(sums) == 0 { return next }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if !strings.HasPrefix(r.URL.Path, "/v1/") { next.ServeHTTP(w, r); return }
+        if !tokenAllowed(sums, r) {
+            const msg = "missing or invalid API key for this adapter"
+            if strings.HasPrefix(r.URL.Path, "/v1/chat/") { writeOpenAIError(w, http.StatusUnauthorized, "authentication_error", msg) } else { writeAnthropicError(w, http.StatusUnauthorized, "authentication_error", msg) }
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// tokenAllowed compares the caller's credential against every configured
+// token in constant time; hashing first keeps token lengths from leaking.
+func tokenAllowed(sums [][sha256.Size]byte, r *http.Request) bool {
+    got := strings.TrimSpace(r.Header.Get("x-api-key"))
+    if auth := r.Header.Get("Authorization"); got == "" && len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") { got = strings.TrimSpace(auth[7:]) }
+    if got == "" { return false }
+    sum := sha256.Sum256([]byte(got))
+    ok := 0
+    for i := range sums { ok |= subtle.ConstantTimeCompare(sum[:], sums[i][:]) }
+    return ok == 1
+}
@@ -0,0 +1,103 @@
+package adapterhttp
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// errCircuitOpen is returned instead of calling an upstream whose breaker is open.
+var errCircuitOpen = errors.New("upstream circuit open after repeated failures; retry later")
+
+// defaultBreakerCooldown is how long an open breaker fails fast before it lets a probe through.
+const defaultBreakerCooldown = 30 * time.Second
+
+// Breaker is a per-upstream-host circuit breaker. After threshold consecutive
+// failures (transport errors or 5xx responses) it opens and requests to that
+// host fail fast with 503 for the cooldown; then a single request is let
+// through (half-open), and its outcome closes or reopens the circuit.
+type Breaker struct {
+    threshold int
+    cooldown  time.Duration
+
+    mu    sync.Mutex
+    hosts map[string]*circuit
+}
+
+type circuit struct {
+    failures int
+    open     bool
+    openedAt time.Time
+    probing  bool // half-open: the one trial request is in flight
+}
+
+// NewBreaker opens a host's circuit after threshold consecutive failures and
+// keeps it open for cooldown (0 uses 30s). threshold <= 0 returns nil, which
+// never trips.
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+    if threshold <= 0 { return nil }
+    if cooldown <= 0 { cooldown = defaultBreakerCooldown }
+    return &Breaker{threshold: threshold, cooldown: cooldown, hosts: map[string]*circuit{}}
+}
+
+func (b *Breaker) circuit(host string) *circuit {
+    c := b.hosts[host]
+    if c == nil { c = &circuit{}; b.hosts[host] = c }
+    return c
+}
+
+// allow reports whether a request to host may go out. Once the cooldown has
+// passed, an open circuit lets exactly one request through as a probe.
+func (b *Breaker) allow(host string) bool {
+    if b == nil { return true }
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    c := b.circuit(host)
+    if !c.open { return true }
+    if c.probing || time.Since(c.openedAt) < b.cooldown { return false }
+    c.probing = true
+    return true
+}
+
+// record reports the outcome of an allowed request. A request the caller
+// abandoned says nothing about the upstream and only frees the probe slot.
+func (b *Breaker) record(host string, failed, abandoned bool) {
+    if b == nil { return }
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    c := b.circuit(host)
+    wasProbe := c.probing
+    c.probing = false
+    switch {
+    case abandoned:
+    case !failed:
+        c.failures, c.open = 0, false
+    case wasProbe:
+        c.openedAt = time.Now()
+    default:
+        c.failures++
+        if c.failures >= b.threshold && !c.open { c.open, c.openedAt = true, time.Now() }
+    }
+}
+
+// isOpen reports whether host's circuit is open and still cooling down.
+func (b *Breaker) isOpen(host string) bool {
+    if b == nil { return false }
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    c := b.hosts[host]
+    return c != nil && c.open && time.Since(c.openedAt) < b.cooldown
+}
+
+// doUpstream sends req through cfg.Breaker: it fails fast with errCircuitOpen
+// while the upstream's circuit is open and records the outcome otherwise.
+func (cfg Config) doUpstream(client *http.Client, req *http.Request) (*http.Response, error) {
+    host := req.URL.Host
+    if !cfg.Breaker.allow(host) { return nil, errCircuitOpen }
+    resp, err := client.Do(req)
+    abandoned := err != nil && errors.Is(req.Context().Err(), context.Canceled)
+    cfg.Breaker.record(host, err != nil || resp.StatusCode >= 500, abandoned)
+    return resp, err
+}
@@ -0,0 +1,101 @@
+package adapterhttp
+
+import (
+    "bytes"
+    "encoding/json"
+    "io"
+    "net/http"
+    "strings"
+
+    "claude-openai-adapter/pkg/adapter"
+)
+
+// convertResult is the body returned by the dry-run conversion endpoint.
+type convertResult struct {
+    From     string           `json:"from"`
+    To       string           `json:"to"`
+    Model    string           `json:"model"` // resolved upstream model, as the proxy handlers would send it
+    Request  interface{}      `json:"request"`
+    Warnings []convertWarning `json:"warnings,omitempty"`
+}
+
+type convertWarning struct {
+    Kind   string `json:"kind"`
+    Detail string `json:"detail"`
+}
+
+// NewConvertHandler returns a debugging endpoint that converts an Anthropic or
+// OpenAI request exactly like the proxy handlers do and returns the result
+// instead of calling an upstream. The source API comes from ?from=anthropic|openai,
+// or is detected from the body's shape.
+func NewConvertHandler(cfg Config) http.Handler {
+    maps := parseModelMaps(cfg)
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost { w.Header().Set("Allow", http.MethodPost); writeAnthropicError(w, http.StatusMethodNotAllowed, "invalid_request_error", "method not allowed"); return }
+        body, err := readBody(w, r, cfg)
+        if err != nil { code, typ := decodeError(err); writeAnthropicError(w, code, typ, err.Error()); return }
+        from := strings.ToLower(r.URL.Query().Get("from"))
+        if from == "" { from = detectRequestAPI(body) }
+        r.Body = io.NopCloser(bytes.NewReader(body))
+
+        var res convertResult
+        opts := cfg.adapterOptions()
+        opts.Warn = func(kind, detail string) {
+            printWarning(kind, detail)
+            res.Warnings = append(res.Warnings, convertWarning{Kind: kind, Detail: detail})
+        }
+        switch from {
+        case "anthropic":
+            var areq adapter.AnthropicMessageRequest
+            if err := decodeBody(w, r, cfg, &areq, opts.Warn); err != nil { code, typ := decodeError(err); writeAnthropicError(w, code, typ, err.Error()); return }
+            if err := validateAnthropicRequest(areq, cfg); err != nil { writeAnthropicError(w, http.StatusBadRequest, "invalid_request_error", err.Error()); return }
+            if cfg.AnthropicRequestTransform != nil { cfg.AnthropicRequestTransform(&areq) }
+            oreq, err := adapter.AnthropicToOpenAI(areq, opts)
+            if err != nil { writeAnthropicError(w, http.StatusBadRequest, "invalid_request_error", "invalid messages: "+err.Error()); return }
+            oreq.Model = mapModelFromConfig(areq.Model, cfg, maps)
+            if m := modelOverride(r); m != "" { oreq.Model = m }
+            res.From, res.To, res.Model, res.Request = "anthropic", "openai", oreq.Model, oreq
+        case "openai":
+            var oreq adapter.OpenAIChatRequest
+            if err := decodeBody(w, r, cfg, &oreq, opts.Warn); err != nil { code, typ := decodeError(err); writeOpenAIError(w, code, adapter.OpenAIErrorType(typ), err.Error()); return }
+            if err := validateOpenAIRequest(oreq, cfg); err != nil { writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", err.Error()); return }
+            if cfg.OpenAIRequestTransform != nil { cfg.OpenAIRequestTransform(&oreq) }
+            areq, err := adapter.OpenAIToAnthropicRequest(oreq, opts)
+            if err != nil { writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "invalid messages: "+err.Error()); return }
+            areq.Model = mapModelToAnthropic(oreq.Model, cfg, maps)
+            if m := modelOverride(r); m != "" { areq.Model = m }
+            res.From, res.To, res.Model, res.Request = "openai", "anthropic", areq.Model, areq
+        default:
+            writeAnthropicError(w, http.StatusBadRequest, "invalid_request_error", "from must be anthropic or openai")
+            return
+        }
+        writeJSON(w, http.StatusOK, res)
+    })
+}
+
+// detectRequestAPI guesses whether body is an OpenAI or Anthropic request from
+// fields and message shapes only one of the APIs uses. Ambiguous bodies (plain
+// user/assistant text) are treated as Anthropic, the adapter's primary client.
+func detectRequestAPI(body []byte) string {
+    var req struct {
+        Messages []struct {
+            Role      string          `json:"role"`
+            Content   json.RawMessage `json:"content"`
+            ToolCalls json.RawMessage `json:"tool_calls"`
+        } `json:"messages"`
+        Stop           json.RawMessage `json:"stop"`
+        ResponseFormat json.RawMessage `json:"response_format"`
+        StreamOptions  json.RawMessage `json:"stream_options"`
+        Functions      json.RawMessage `json:"functions"`
+    }
+    if json.Unmarshal(body, &req) != nil { return "anthropic" }
+    if req.Stop != nil || req.ResponseFormat != nil || req.StreamOptions != nil || req.Functions != nil { return "openai" }
+    for _, m := range req.Messages {
+        switch m.Role {
+        case "system", "developer", "tool", "function": return "openai"
+        }
+        if m.ToolCalls != nil { return "openai" }
+        if strings.Contains(string(m.Content), `"image_url"`) { return "openai" }
+    }
+    return "anthropic"
+}
@@ -0,0 +1,43 @@
+package adapterhttp
+
+import (
+    "net/http"
+    "strings"
+)
+
+const (
+    corsAllowMethods = "GET, POST, OPTIONS"
+    corsAllowHeaders = "Authorization, Content-Type, x-api-key, anthropic-version, anthropic-beta, OpenAI-Organization, OpenAI-Project, X-Request-Id, X-Adapter-Model"
+    corsMaxAge       = "600"
+)
+
+// CORS lets browser clients on the given origins call next. "*" allows any
+// origin; an empty list returns next unchanged. Preflight OPTIONS requests are
+// answered here with 204 and never reach next. Requests from other origins are
+// served without CORS headers, so the browser blocks them.
+func CORS(origins []string, next http.Handler) http.Handler {
+    if len(origins) == 0 { return next }
+    allowed := make(map[string]bool, len(origins))
+    allowAll := false
+    for _, o := range origins {
+        o = strings.TrimRight(strings.TrimSpace(o), "/")
+        if o == "*" { allowAll = true }
+        if o != "" { allowed[strings.ToLower(o)] = true }
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        origin := r.Header.Get("Origin")
+        if origin == "" || !(allowAll || allowed[strings.ToLower(origin)]) { next.ServeHTTP(w, r); return }
+        h := w.Header()
+        h.Add("Vary", "Origin")
+        if allowAll { h.Set("Access-Control-Allow-Origin", "*") } else { h.Set("Access-Control-Allow-Origin", origin) }
+        h.Set("Access-Control-Expose-Headers", requestIDHeader)
+        if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+            h.Set("Access-Control-Allow-Methods", corsAllowMethods)
+            h.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+            h.Set("Access-Control-Max-Age", corsMaxAge)
+            w.WriteHeader(http.StatusNoContent)
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
@@ -0,0 +1,98 @@
+package adapterhttp
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+
+    "claude-openai-adapter/pkg/adapter"
+)
+
+// writeAnthropicError writes an error in the Anthropic Messages API shape.
+func writeAnthropicError(w http.ResponseWriter, code int, errType, msg string) {
+    writeJSON(w, code, map[string]interface{}{"type": "error", "error": map[string]interface{}{"type": errType, "message": msg}})
+}
+
+// writeOpenAIError writes an error in the OpenAI Chat Completions API shape.
+func writeOpenAIError(w http.ResponseWriter, code int, errType, msg string) {
+    writeJSON(w, code, map[string]interface{}{"error": map[string]interface{}{"message": msg, "type": errType, "param": nil, "code": nil}})
+}
+
+// sseHeaders sets the headers shared by every SSE response.
+func sseHeaders(w http.ResponseWriter) {
+    w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Header().Set("Connection", "keep-alive")
+}
+
+// writeAnthropicStreamError reports an error to an Anthropic streaming client as an SSE error event,
+// so clients that asked for a stream never have to parse a plain-text body.
+func writeAnthropicStreamError(w http.ResponseWriter, code int, errType, msg string) {
+    sseHeaders(w)
+    w.WriteHeader(code)
+    b, _ := json.Marshal(map[string]interface{}{"type": "error", "error": map[string]interface{}{"type": errType, "message": msg}})
+    fmt.Fprintf(w, "event: error\ndata: %s\n\n", string(b))
+    if f, ok := w.(http.Flusher); ok { f.Flush() }
+}
+
+// writeOpenAIStreamError reports an error to an OpenAI streaming client as an error chunk followed by [DONE].
+func writeOpenAIStreamError(w http.ResponseWriter, code int, errType, msg string) {
+    sseHeaders(w)
+    w.WriteHeader(code)
+    b, _ := json.Marshal(map[string]interface{}{"error": map[string]interface{}{"message": msg, "type": errType, "param": nil, "code": nil}})
+    fmt.Fprintf(w, "data: %s\n\ndata: [DONE]\n\n", string(b))
+    if f, ok := w.(http.Flusher); ok { f.Flush() }
+}
+
+const defaultOverloadedStatus = 529 // Anthropic's own status for overloaded_error
+
+// statusForAnthropicError is the HTTP status reported to clients for an Anthropic
+// error type. Unknown types and api_error are upstream failures, so 502.
+func statusForAnthropicError(errType string, cfg Config) int {
+    switch errType {
+    case "invalid_request_error": return http.StatusBadRequest
+    case "authentication_error": return http.StatusUnauthorized
+    case "permission_error": return http.StatusForbidden
+    case "not_found_error": return http.StatusNotFound
+    case "request_too_large": return http.StatusRequestEntityTooLarge
+    case "rate_limit_error": return http.StatusTooManyRequests
+    case "overloaded_error":
+        if cfg.OverloadedStatus > 0 { return cfg.OverloadedStatus }
+        return defaultOverloadedStatus
+    }
+    return http.StatusBadGateway
+}
+
+// anthropicErrorForStatus is the reverse of statusForAnthropicError for an upstream HTTP status.
+func anthropicErrorForStatus(status int) string {
+    switch status {
+    case http.StatusBadRequest, http.StatusUnprocessableEntity: return "invalid_request_error"
+    case http.StatusUnauthorized: return "authentication_error"
+    case http.StatusForbidden: return "permission_error"
+    case http.StatusNotFound: return "not_found_error"
+    case http.StatusRequestEntityTooLarge: return "request_too_large"
+    case http.StatusTooManyRequests: return "rate_limit_error"
+    case http.StatusServiceUnavailable, defaultOverloadedStatus: return "overloaded_error"
+    }
+    return "api_error"
+}
+
+// upstreamErrorType classifies an upstream error response in the Anthropic error
+// vocabulary, preferring the type named in the body (either API's shape) over the status.
+func upstreamErrorType(status int, body []byte) string {
+    var e struct {
+        Error struct {
+            Type string      `json:"type"`
+            Code interface{} `json:"code"`
+        } `json:"error"`
+    }
+    if json.Unmarshal(body, &e) != nil || e.Error.Type == "" { return anthropicErrorForStatus(status) }
+    switch e.Error.Type {
+    case "invalid_request_error", "authentication_error", "permission_error", "not_found_error", "request_too_large", "rate_limit_error", "overloaded_error":
+        return e.Error.Type
+    }
+    code := ""
+    if e.Error.Code != nil { code = fmt.Sprint(e.Error.Code) }
+    if t := adapter.AnthropicErrorType(e.Error.Type, code); t != "api_error" { return t }
+    return anthropicErrorForStatus(status)
+}
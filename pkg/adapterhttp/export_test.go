@@ -0,0 +1,14 @@
+package adapterhttp
+
+import "net/http"
+
+// ModelMapper returns mapModelFromConfig bound to cfg's parsed model maps, as a handler uses it.
+func ModelMapper(cfg Config) func(anthropicModel string) string {
+    maps := parseModelMaps(cfg)
+    return func(m string) string { return mapModelFromConfig(m, cfg, maps) }
+}
+
+// NewSSEEventWriter returns the stream proxies' event writer over w.
+func NewSSEEventWriter(w http.ResponseWriter) func(event string, payload interface{}) {
+    return newSSEStream(w, w.(http.Flusher), func() {}).writeEvent
+}
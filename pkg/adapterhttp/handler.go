@@ -4,10 +4,13 @@ import (
     "bytes"
     "context"
     "encoding/json"
+    "errors"
     "fmt"
     "io"
     "net/http"
+    "net/url"
     "os"
+    "sort"
     "strconv"
     "strings"
     "time"
@@ -18,6 +21,7 @@ import (
 var (
     debugEnabled  = false
     logEvents     = false
+    logJSON       = false
 )
 
 // SetDebug enables verbose logging for the adapter
@@ -26,39 +30,273 @@ func SetDebug(v bool) { debugEnabled = v }
 // SetLogEvents controls per-event SSE logging
 func SetLogEvents(v bool) { logEvents = v }
 
+// SetLogJSON makes Logging print one JSON object per request instead of a text line
+func SetLogJSON(v bool) { logJSON = v }
+
 type Config struct {
-    AnthropicBaseURL   string
-    AnthropicAPIKey    string
-    AnthropicVersion   string
-    OpenAIBaseURL      string
-    OpenAIAPIKey       string
-    ModelMap           string // line-delimited: "claude-x=gpt-y"
-    DefaultOpenAIModel string // fallback when mapping missing
+    AnthropicBaseURL      string
+    AnthropicAPIKey       string
+    AnthropicVersion      string
+    OpenAIBaseURL         string
+    OpenAIAPIKey          string        // sent as a Bearer token, or as the api-key header for Azure
+    AzureOpenAI           bool          // OpenAI upstream is Azure: deployment URLs (deployment = mapped model) and api-key auth
+    AzureAPIVersion       string        // api-version query for Azure; empty uses defaultAzureAPIVersion
+    ModelMap              string        // line-delimited: "claude-x=gpt-y"
+    DefaultOpenAIModel    string        // fallback when mapping missing
+    ReverseModelMap       string        // line-delimited: "gpt-y=claude-x"; falls back to the inverse of ModelMap
+    DefaultAnthropicModel string        // fallback for /v1/chat/completions when unmapped; empty passes the model through
+    RedactContent         bool          // redact user content in debug output copied from upstream bodies
+    MaxToolCallsPerTurn   int           // cap on tool calls per assistant turn; 0 means unlimited
+    FailOnMaxToolCalls    bool          // fail the response instead of dropping tool calls beyond the cap
+    MaxRequestBytes       int64         // request body cap, answered with 413; 0 uses defaultMaxRequestBytes (10MB)
+    MaxMessages           int           // cap on messages per request; 0 uses defaultMaxMessages
+    MaxImagePixels        int           // reject inline PNG/JPEG images above width*height; 0 disables
+    MaxStopSequences      int           // cap on stop sequences sent upstream; 0 uses the target provider's default
+    NormalizeToolPaths    bool          // rewrite backslashes to "/" in path/file_path tool arguments of non-streaming responses
+    ReportUpstreamModel   bool          // report the upstream model instead of the requested one in Anthropic streams
+    PlaceholderUserText   string        // first user turn injected when an OpenAI conversation doesn't start with one; empty uses "."
+    OverloadedStatus      int           // status reported for upstream overloaded errors; 0 uses 529 (503 suits clients that don't know 529)
+    DisableKeepAlives     bool          // open a fresh upstream connection per request (for upstreams with flaky connection reuse)
+    ScaleTemperature      bool          // scale temperature between the APIs' ranges instead of clamping it
+    TrimSystem            bool          // trim surrounding whitespace from system prompts instead of forwarding them verbatim
+    JSONModePrompt        bool          // translate response_format into a system-prompt JSON instruction toward Anthropic
+    EmptyAssistantText    string        // text for empty OpenAI assistant turns toward Anthropic; empty drops them and merges the user turns around them
+    AnthropicSSECompat    bool          // strict-client mode for Anthropic streams: "id:" line on every event, spec-cased event names
+    StructuredContent     bool          // chat completion content as an array of text parts, one per Anthropic text block
+    Transcript            *Transcript   // records upstream requests and responses as JSONL; nil disables
+    TranscriptRaw         bool          // record transcript bodies unredacted; by default user content is redacted
+    ForwardHeaders        []string      // caller headers passed through to the upstream; nil uses defaultForwardHeaders
+    Limiter               *Limiter      // bounds concurrent upstream calls across handlers sharing it; nil is unlimited
+    Breaker               *Breaker      // fails fast with 503 while an upstream keeps failing; nil disables
+    SSEMaxLine            int           // longest upstream SSE line accepted; 0 uses adapter.DefaultSSEMaxLine
+    SSEPingInterval       time.Duration // idle keepalive interval for streams; 0 uses defaultSSEPingInterval, negative disables
+
+    // Request hooks run after a request is decoded and validated, before conversion;
+    // nil is a no-op. They let embedders rewrite requests (strip tools, inject context, ...).
+    AnthropicRequestTransform func(*adapter.AnthropicMessageRequest) // /v1/messages
+    OpenAIRequestTransform    func(*adapter.OpenAIChatRequest)       // /v1/chat/completions
+    // Response hooks run on a non-streaming response after it is mapped, before it is written.
+    AnthropicResponseTransform func(*adapter.AnthropicMessageResponse) // /v1/messages
+    OpenAIResponseTransform    func(*adapter.OpenAIChatResponse)       // /v1/chat/completions
+}
+
+const (
+    defaultMaxRequestBytes = 10 << 20
+    defaultMaxMessages     = 10000
+    maxJSONDepth           = 128
+    defaultAzureAPIVersion = "2024-10-21"
+)
+
+// NewUpstreamClient builds the HTTP client used for upstream calls. It starts
+// from a clone of http.DefaultTransport so proxy and timeout defaults carry over.
+func NewUpstreamClient(cfg Config) *http.Client {
+    tr := &http.Transport{Proxy: http.ProxyFromEnvironment}
+    if dt, ok := http.DefaultTransport.(*http.Transport); ok { tr = dt.Clone() }
+    // stale pooled connections on some upstreams surface as EOFs; trade reuse for reliability
+    tr.DisableKeepAlives = cfg.DisableKeepAlives
+    return &http.Client{Transport: tr}
+}
+
+// adapterOptions builds the conversion options for this config.
+func (cfg Config) adapterOptions() adapter.Options {
+    return adapter.Options{Warn: logWarning, MaxToolCalls: cfg.MaxToolCallsPerTurn, FailOnMaxToolCalls: cfg.FailOnMaxToolCalls, MaxStopSequences: cfg.MaxStopSequences, NormalizeToolPaths: cfg.NormalizeToolPaths, ReportUpstreamModel: cfg.ReportUpstreamModel, PlaceholderUserText: cfg.PlaceholderUserText, ScaleTemperature: cfg.ScaleTemperature, TrimSystem: cfg.TrimSystem, JSONModePrompt: cfg.JSONModePrompt, EmptyAssistantText: cfg.EmptyAssistantText, StructuredContent: cfg.StructuredContent, MaxSSELine: cfg.SSEMaxLine}
+}
+
+// logWarning counts and prints a conversion warning.
+func logWarning(kind, detail string) {
+    countWarning(kind, detail)
+    printWarning(kind, detail)
+}
+
+// printWarning prints a conversion warning. Dropped parameters the target API has
+// no equivalent for are routine, so they are only printed in debug mode.
+func printWarning(kind, detail string) {
+    if kind == "unsupported_param" && !debugEnabled { return }
+    fmt.Printf("[adapter/warn] %s: %s\n", kind, detail)
 }
 
 func trimRightSlash(s string) string { return strings.TrimRight(s, "/") }
 
-func mapModelFromConfig(anthropicModel string, cfg Config) string {
-    mm := cfg.ModelMap
-    if mm != "" {
-        scanner := strings.NewReader(mm)
-        var buf strings.Builder
-        line := ""
-        data, _ := io.ReadAll(scanner)
-        for _, ch := range strings.Split(string(data), "\n") {
-            line = strings.TrimSpace(ch)
-            if line == "" || strings.HasPrefix(line, "#") { continue }
-            kv := strings.SplitN(line, "=", 2)
-            if len(kv) == 2 && strings.TrimSpace(kv[0]) == anthropicModel {
-                return strings.TrimSpace(kv[1])
-            }
-        }
-        _ = buf
-    }
+// mapModelFromConfig resolves an Anthropic model name to an OpenAI model using
+// ModelMap, then DefaultOpenAIModel.
+func mapModelFromConfig(anthropicModel string, cfg Config, maps modelMaps) string {
+    if m, ok := maps.model.fwd[anthropicModel]; ok { return m }
     if cfg.DefaultOpenAIModel != "" { return cfg.DefaultOpenAIModel }
     return "gpt-4o-mini"
 }
 
+// modelMap is a parsed model map, looked up by key (fwd) or by value (inv).
+// The first line naming a model wins.
+type modelMap struct{ fwd, inv map[string]string }
+
+// modelMaps holds a Config's ModelMap and ReverseModelMap, parsed once when a
+// handler is built rather than on every request.
+type modelMaps struct{ model, reverse modelMap }
+
+func parseModelMaps(cfg Config) modelMaps {
+    return modelMaps{model: parseModelMap(cfg.ModelMap), reverse: parseModelMap(cfg.ReverseModelMap)}
+}
+
+func parseModelMap(mm string) modelMap {
+    m := modelMap{fwd: map[string]string{}, inv: map[string]string{}}
+    for _, kv := range parseModelPairs(mm) {
+        if _, ok := m.fwd[kv[0]]; !ok { m.fwd[kv[0]] = kv[1] }
+        if _, ok := m.inv[kv[1]]; !ok { m.inv[kv[1]] = kv[0] }
+    }
+    return m
+}
+
+// parseModelPairs parses "a=b" lines, skipping blanks and # comments.
+func parseModelPairs(mm string) [][2]string {
+    var out [][2]string
+    for _, ln := range strings.Split(mm, "\n") {
+        ln = strings.TrimSpace(ln)
+        if ln == "" || strings.HasPrefix(ln, "#") { continue }
+        kv := strings.SplitN(ln, "=", 2)
+        if len(kv) != 2 { continue }
+        out = append(out, [2]string{strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])})
+    }
+    return out
+}
+
+// mapModelToAnthropic resolves an OpenAI model name to an Anthropic model id
+// using ReverseModelMap, then the inverse of ModelMap, then DefaultAnthropicModel.
+func mapModelToAnthropic(openaiModel string, cfg Config, maps modelMaps) string {
+    if m, ok := maps.reverse.fwd[openaiModel]; ok { return m }
+    if m, ok := maps.model.inv[openaiModel]; ok { return m }
+    if cfg.DefaultAnthropicModel != "" { return cfg.DefaultAnthropicModel }
+    return openaiModel
+}
+
+// modelOverride returns the per-request upstream model from X-Adapter-Model, if any.
+// It wins over the model maps and defaults.
+func modelOverride(r *http.Request) string { return strings.TrimSpace(r.Header.Get("X-Adapter-Model")) }
+
+// errBodyTooLarge marks decodeBody errors for bodies over the size cap.
+var errBodyTooLarge = errors.New("request body too large")
+
+// decodeBody reads the request body under the configured size cap and rejects
+// pathologically nested JSON before handing it to json.Unmarshal. Corrections
+// made to the body are reported to warn.
+func decodeBody(w http.ResponseWriter, r *http.Request, cfg Config, v interface{}, warn func(kind, detail string)) error {
+    body, err := readBody(w, r, cfg)
+    if err != nil { return err }
+    if err := checkJSONDepth(body, maxJSONDepth); err != nil { return err }
+    if _, ok := v.(*adapter.OpenAIChatRequest); ok { body = normalizeIndexedMessages(body, warn) }
+    if err := json.Unmarshal(body, v); err != nil { return errors.New("invalid json") }
+    return nil
+}
+
+// readBody reads the request body up to cfg.MaxRequestBytes; a larger body fails with errBodyTooLarge.
+func readBody(w http.ResponseWriter, r *http.Request, cfg Config) ([]byte, error) {
+    limit := cfg.MaxRequestBytes
+    if limit <= 0 { limit = defaultMaxRequestBytes }
+    body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+    var tooLarge *http.MaxBytesError
+    if errors.As(err, &tooLarge) { return nil, fmt.Errorf("%w: limit is %d bytes", errBodyTooLarge, limit) }
+    if err != nil { return nil, fmt.Errorf("read body: %w", err) }
+    return body, nil
+}
+
+// decodeError maps a decodeBody error to a status and Anthropic error type:
+// 413 request_too_large over the size cap, 400 otherwise.
+func decodeError(err error) (int, string) {
+    if errors.Is(err, errBodyTooLarge) { return http.StatusRequestEntityTooLarge, "request_too_large" }
+    return http.StatusBadRequest, "invalid_request_error"
+}
+
+// normalizeIndexedMessages rewrites a "messages" object keyed by index ({"0":{...},"1":{...}}),
+// as sent by some buggy clients, into an array ordered by numeric key, and tells warn.
+// Other bodies are returned unchanged.
+func normalizeIndexedMessages(body []byte, warn func(kind, detail string)) []byte {
+    var top map[string]json.RawMessage
+    if err := json.Unmarshal(body, &top); err != nil { return body }
+    raw := bytes.TrimSpace(top["messages"])
+    if len(raw) == 0 || raw[0] != '{' { return body }
+    var byKey map[string]json.RawMessage
+    if err := json.Unmarshal(raw, &byKey); err != nil { return body }
+    type indexed struct { n int; msg json.RawMessage }
+    items := make([]indexed, 0, len(byKey))
+    for k, m := range byKey {
+        n, err := strconv.Atoi(k)
+        if err != nil || n < 0 { return body }
+        items = append(items, indexed{n, m})
+    }
+    sort.Slice(items, func(i, j int) bool { return items[i].n < items[j].n })
+    msgs := make([]json.RawMessage, 0, len(items))
+    for _, it := range items { msgs = append(msgs, it.msg) }
+    top["messages"], _ = json.Marshal(msgs)
+    out, err := json.Marshal(top)
+    if err != nil { return body }
+    warn("indexed_messages", fmt.Sprintf("normalized %d messages sent as an object keyed by index", len(msgs)))
+    return out
+}
+
+// checkJSONDepth scans raw JSON and fails once object/array nesting exceeds max.
+func checkJSONDepth(b []byte, max int) error {
+    depth := 0
+    inStr, esc := false, false
+    for _, c := range b {
+        if inStr {
+            if esc { esc = false } else if c == '\\' { esc = true } else if c == '"' { inStr = false }
+            continue
+        }
+        switch c {
+        case '"':
+            inStr = true
+        case '{', '[':
+            depth++
+            if depth > max { return fmt.Errorf("json nesting exceeds depth %d", max) }
+        case '}', ']':
+            depth--
+        }
+    }
+    return nil
+}
+
+func checkMessageCount(n int, cfg Config) error {
+    if n == 0 { return errNoMessages }
+    max := cfg.MaxMessages
+    if max <= 0 { max = defaultMaxMessages }
+    if n > max { return fmt.Errorf("too many messages: %d > %d", n, max) }
+    return nil
+}
+
+// errNoMessages rejects a request with no messages, or none left after conversion.
+var errNoMessages = errors.New("messages must not be empty")
+
+// noOpenAIConversation reports whether msgs has nothing for the model to answer:
+// no message besides system ones carrying content or tool calls.
+func noOpenAIConversation(msgs []adapter.OpenAIMessage) bool {
+    for _, m := range msgs {
+        if m.Role == "system" || m.Role == "developer" { continue }
+        if len(m.ToolCalls) > 0 || m.FunctionCall != nil { return false }
+        if s, ok := m.Content.(string); (ok && s != "") || (!ok && m.Content != nil) { return false }
+    }
+    return true
+}
+
+// validateAnthropicRequest runs pre-flight checks against Anthropic API limits.
+func validateAnthropicRequest(areq adapter.AnthropicMessageRequest, cfg Config) error {
+    if err := checkMessageCount(len(areq.Messages), cfg); err != nil { return err }
+    if t := areq.Temperature; t != nil && (*t < 0 || *t > 1) { return fmt.Errorf("temperature must be between 0 and 1, got %g", *t) }
+    if p := areq.TopP; p != nil && (*p < 0 || *p > 1) { return fmt.Errorf("top_p must be between 0 and 1, got %g", *p) }
+    if err := checkAnthropicImages(areq.Messages, cfg.MaxImagePixels); err != nil { return err }
+    return nil
+}
+
+// validateOpenAIRequest runs pre-flight checks against OpenAI API limits.
+func validateOpenAIRequest(oreq adapter.OpenAIChatRequest, cfg Config) error {
+    if err := checkMessageCount(len(oreq.Messages), cfg); err != nil { return err }
+    if t := oreq.Temperature; t != nil && (*t < 0 || *t > 2) { return fmt.Errorf("temperature must be between 0 and 2, got %g", *t) }
+    if p := oreq.TopP; p != nil && (*p < 0 || *p > 1) { return fmt.Errorf("top_p must be between 0 and 1, got %g", *p) }
+    if n := oreq.N; n != nil && *n != 1 { return fmt.Errorf("n must be 1, got %d: Anthropic returns a single choice per request", *n) }
+    for _, m := range oreq.Messages {
+        if err := checkImagePixels(m.Content, cfg.MaxImagePixels); err != nil { return err }
+    }
+    return nil
+}
+
 func writeJSON(w http.ResponseWriter, code int, v interface{}) {
     w.Header().Set("Content-Type", "application/json")
     w.WriteHeader(code)
@@ -69,25 +307,34 @@ func writeJSON(w http.ResponseWriter, code int, v interface{}) {
 func NewMessagesHandler(cfg Config, client *http.Client) http.Handler {
     if client == nil { client = http.DefaultClient }
     base := trimRightSlash(cfg.OpenAIBaseURL)
+    maps := parseModelMaps(cfg)
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        if r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
+        if r.Method != http.MethodPost { w.Header().Set("Allow", http.MethodPost); writeAnthropicError(w, http.StatusMethodNotAllowed, "invalid_request_error", "method not allowed"); return }
+        r = r.WithContext(withForwardedHeaders(r, cfg))
         var areq adapter.AnthropicMessageRequest
-        if err := json.NewDecoder(r.Body).Decode(&areq); err != nil { http.Error(w, "invalid json", http.StatusBadRequest); return }
+        if err := decodeBody(w, r, cfg, &areq, logWarning); err != nil { code, typ := decodeError(err); writeAnthropicError(w, code, typ, err.Error()); return }
+        if err := validateAnthropicRequest(areq, cfg); err != nil { writeAnthropicError(w, http.StatusBadRequest, "invalid_request_error", err.Error()); return }
+        if cfg.AnthropicRequestTransform != nil { cfg.AnthropicRequestTransform(&areq) }
         if areq.Stream && debugNoStream(r) { areq.Stream = false }
-        oreq, err := adapter.AnthropicToOpenAI(areq)
-        if err != nil { http.Error(w, "invalid messages: "+err.Error(), http.StatusBadRequest); return }
+        oreq, err := adapter.AnthropicToOpenAI(areq, cfg.adapterOptions())
+        if err != nil { writeAnthropicError(w, http.StatusBadRequest, "invalid_request_error", "invalid messages: "+err.Error()); return }
+        if noOpenAIConversation(oreq.Messages) { writeAnthropicError(w, http.StatusBadRequest, "invalid_request_error", errNoMessages.Error()+": every message converted to nothing"); return }
         // Apply model mapping via config
-        oreq.Model = mapModelFromConfig(areq.Model, cfg)
+        oreq.Model = mapModelFromConfig(areq.Model, cfg, maps)
+        if m := modelOverride(r); m != "" { oreq.Model = m }
         if debugEnabled {
             info := map[string]interface{}{"model": areq.Model, "stream": areq.Stream, "messages": len(areq.Messages), "tools": len(areq.Tools)}
             b, _ := json.Marshal(info)
             fmt.Printf("[adapter/messages] incoming=%s\n", string(b))
         }
+        release, ok := cfg.Limiter.acquire(r.Context())
+        if !ok { w.Header().Set("Retry-After", "1"); writeAnthropicError(w, http.StatusServiceUnavailable, "overloaded_error", errSaturated); return }
+        defer release() // streams are relayed before the handler returns
         if areq.Stream {
-            proxyStream(w, r.Context(), client, base, cfg.OpenAIAPIKey, oreq, areq)
+            proxyStream(w, r.Context(), client, base, cfg, oreq, areq)
             return
         }
-        proxyOnce(w, r.Context(), client, base, cfg.OpenAIAPIKey, oreq, areq)
+        proxyOnce(w, r.Context(), client, base, cfg, oreq, areq)
     })
 }
 
@@ -101,6 +348,47 @@ func preview(b []byte, max int) []byte {
     return out
 }
 
+// mappingError reports a failure to map an upstream response through writeErr,
+// in the client's error shape. In debug mode the
+// message carries a truncated copy of the upstream body, redacted when
+// cfg.RedactContent is set, to help diagnose mapping bugs.
+func mappingError(w http.ResponseWriter, cfg Config, writeErr func(http.ResponseWriter, int, string, string), errType, msg string, upstream []byte) {
+    if debugEnabled {
+        body := upstream
+        if cfg.RedactContent { body = redactJSON(body) }
+        msg += "; upstream body: " + string(preview(body, 1024))
+    }
+    writeErr(w, http.StatusBadGateway, errType, msg)
+}
+
+// redactKeys are JSON object keys whose string values may carry user content.
+var redactKeys = map[string]bool{"text": true, "content": true, "arguments": true, "partial_json": true, "input": true, "thinking": true, "data": true}
+
+// redactJSON replaces user-content values in a JSON document with "[redacted]".
+// Bodies that are not valid JSON are redacted wholesale.
+func redactJSON(b []byte) []byte {
+    var v interface{}
+    if err := json.Unmarshal(b, &v); err != nil { return []byte("[redacted]") }
+    out, _ := json.Marshal(redactValue(v))
+    return out
+}
+
+func redactValue(v interface{}) interface{} {
+    switch t := v.(type) {
+    case map[string]interface{}:
+        for k, val := range t {
+            if redactKeys[k] {
+                if _, isStr := val.(string); isStr { t[k] = "[redacted]"; continue }
+                if _, isObj := val.(map[string]interface{}); isObj && k == "input" { t[k] = "[redacted]"; continue }
+            }
+            t[k] = redactValue(val)
+        }
+    case []interface{}:
+        for i := range t { t[i] = redactValue(t[i]) }
+    }
+    return v
+}
+
 type statusWriter struct { http.ResponseWriter; status int; written int }
 func (s *statusWriter) WriteHeader(code int) { s.status = code; s.ResponseWriter.WriteHeader(code) }
 func (s *statusWriter) Write(b []byte) (int, error) { n, err := s.ResponseWriter.Write(b); s.written += n; return n, err }
@@ -109,128 +397,199 @@ func (s *statusWriter) Write(b []byte) (int, error) { n, err := s.ResponseWriter
 func NewChatCompletionsHandler(cfg Config, client *http.Client) http.Handler {
     if client == nil { client = http.DefaultClient }
     base := trimRightSlash(cfg.AnthropicBaseURL)
+    maps := parseModelMaps(cfg)
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        if r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
+        if r.Method != http.MethodPost { w.Header().Set("Allow", http.MethodPost); writeOpenAIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "method not allowed"); return }
+        r = r.WithContext(withForwardedHeaders(r, cfg))
         var oreq adapter.OpenAIChatRequest
-        if err := json.NewDecoder(r.Body).Decode(&oreq); err != nil { http.Error(w, "invalid json", http.StatusBadRequest); return }
+        if err := decodeBody(w, r, cfg, &oreq, logWarning); err != nil { code, typ := decodeError(err); writeOpenAIError(w, code, adapter.OpenAIErrorType(typ), err.Error()); return }
+        if err := validateOpenAIRequest(oreq, cfg); err != nil { writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", err.Error()); return }
+        if cfg.OpenAIRequestTransform != nil { cfg.OpenAIRequestTransform(&oreq) }
         if oreq.Stream && debugNoStream(r) { oreq.Stream = false }
-        areq, err := adapter.OpenAIToAnthropicRequest(oreq)
-        if err != nil { http.Error(w, "invalid messages: "+err.Error(), http.StatusBadRequest); return }
+        areq, err := adapter.OpenAIToAnthropicRequest(oreq, cfg.adapterOptions())
+        if err != nil { writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "invalid messages: "+err.Error()); return }
+        if len(areq.Messages) == 0 { writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", errNoMessages.Error()+": every message converted to nothing"); return }
+        areq.Model = mapModelToAnthropic(oreq.Model, cfg, maps)
+        if m := modelOverride(r); m != "" { areq.Model = m }
+        opts := cfg.adapterOptions()
+        opts.LegacyFunctions = len(oreq.Functions) > 0 && len(oreq.Tools) == 0
+        release, ok := cfg.Limiter.acquire(r.Context())
+        if !ok { w.Header().Set("Retry-After", "1"); writeOpenAIError(w, http.StatusServiceUnavailable, adapter.OpenAIErrorType("overloaded_error"), errSaturated); return }
+        defer release()
         if areq.Stream {
-            proxyToAnthropicStream(w, r.Context(), client, base, cfg, areq, oreq.Model)
+            opts.IncludeUsage = oreq.StreamOptions != nil && oreq.StreamOptions.IncludeUsage
+            proxyToAnthropicStream(w, r.Context(), client, base, cfg, areq, oreq.Model, opts)
             return
         }
-        proxyToAnthropicOnce(w, r.Context(), client, base, cfg, areq, oreq.Model)
+        proxyToAnthropicOnce(w, r.Context(), client, base, cfg, areq, oreq.Model, opts)
     })
 }
 
-func proxyOnce(w http.ResponseWriter, ctx context.Context, client *http.Client, base, apiKey string, oreq adapter.OpenAIChatRequest, areq adapter.AnthropicMessageRequest) {
-    reqBody, _ := json.Marshal(oreq)
-    req, _ := http.NewRequestWithContext(ctx, http.MethodPost, base+"/v1/chat/completions", bytes.NewReader(reqBody))
+// newOpenAIRequest builds the upstream chat completions request: the standard
+// OpenAI path with a Bearer token, or with AzureOpenAI the deployment path,
+// api-version query and api-key header, the deployment being the mapped model.
+func (cfg Config) newOpenAIRequest(ctx context.Context, base, model string, body []byte) *http.Request {
+    u := base + "/v1/chat/completions"
+    if cfg.AzureOpenAI {
+        version := cfg.AzureAPIVersion
+        if version == "" { version = defaultAzureAPIVersion }
+        u = base + "/openai/deployments/" + url.PathEscape(model) + "/chat/completions?api-version=" + url.QueryEscape(version)
+    }
+    req, _ := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+    setUpstreamRequestID(req)
+    setForwardedHeaders(req)
     req.Header.Set("Content-Type", "application/json")
-    if apiKey != "" { req.Header.Set("Authorization", "Bearer "+apiKey) }
-    resp, err := client.Do(req)
-    if err != nil { http.Error(w, "openai request failed: "+err.Error(), http.StatusBadGateway); return }
+    if cfg.OpenAIAPIKey != "" {
+        if cfg.AzureOpenAI { req.Header.Set("api-key", cfg.OpenAIAPIKey) } else { req.Header.Set("Authorization", "Bearer "+cfg.OpenAIAPIKey) }
+    }
+    return req
+}
+
+func proxyOnce(w http.ResponseWriter, ctx context.Context, client *http.Client, base string, cfg Config, oreq adapter.OpenAIChatRequest, areq adapter.AnthropicMessageRequest) {
+    reqBody, _ := json.Marshal(oreq)
+    cfg.transcribe(ctx, "request", "openai", oreq.Model, false, 0, reqBody)
+    req := cfg.newOpenAIRequest(ctx, base, oreq.Model, reqBody)
+    resp, err := cfg.doUpstream(client, req)
+    if errors.Is(err, errCircuitOpen) { writeAnthropicError(w, http.StatusServiceUnavailable, "overloaded_error", err.Error()); return }
+    if err != nil { writeAnthropicError(w, http.StatusBadGateway, "api_error", "openai request failed: "+err.Error()); return }
     defer resp.Body.Close()
     if resp.StatusCode >= 300 {
         body, _ := io.ReadAll(io.LimitReader(resp.Body, 8192))
-        http.Error(w, fmt.Sprintf("openai error %d: %s", resp.StatusCode, string(body)), http.StatusBadGateway)
+        cfg.transcribe(ctx, "response", "openai", oreq.Model, false, resp.StatusCode, body)
+        errType := upstreamErrorType(resp.StatusCode, body)
+        writeAnthropicError(w, statusForAnthropicError(errType, cfg), errType, fmt.Sprintf("openai error %d: %s", resp.StatusCode, string(body)))
         return
     }
+    raw, err := io.ReadAll(resp.Body)
+    if err != nil { writeAnthropicError(w, http.StatusBadGateway, "api_error", "openai read failed: "+err.Error()); return }
+    cfg.transcribe(ctx, "response", "openai", oreq.Model, false, resp.StatusCode, raw)
     var oresp adapter.OpenAIChatResponse
-    if err := json.NewDecoder(resp.Body).Decode(&oresp); err != nil { http.Error(w, "invalid openai response", http.StatusBadGateway); return }
-    aresp, err := adapter.OpenAIToAnthropic(oresp, areq.Model)
-    if err != nil { http.Error(w, "mapping error: "+err.Error(), http.StatusBadGateway); return }
+    if err := json.Unmarshal(raw, &oresp); err != nil { mappingError(w, cfg, writeAnthropicError, "api_error", "invalid openai response", raw); return }
+    aresp, err := adapter.OpenAIToAnthropic(oresp, areq.Model, cfg.adapterOptions())
+    if err != nil { mappingError(w, cfg, writeAnthropicError, "api_error", "mapping error: "+err.Error(), raw); return }
+    if cfg.AnthropicResponseTransform != nil { cfg.AnthropicResponseTransform(&aresp) }
     writeJSON(w, http.StatusOK, aresp)
 }
 
-func proxyStream(w http.ResponseWriter, ctx context.Context, client *http.Client, base, apiKey string, oreq adapter.OpenAIChatRequest, areq adapter.AnthropicMessageRequest) {
+func proxyStream(w http.ResponseWriter, ctx context.Context, client *http.Client, base string, cfg Config, oreq adapter.OpenAIChatRequest, areq adapter.AnthropicMessageRequest) {
+    // Cancelled on the first failed client write so the upstream stream stops promptly.
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
     oreq.Stream = true
     reqBody, _ := json.Marshal(oreq)
-    req, _ := http.NewRequestWithContext(ctx, http.MethodPost, base+"/v1/chat/completions", bytes.NewReader(reqBody))
-    req.Header.Set("Content-Type", "application/json")
+    cfg.transcribe(ctx, "request", "openai", oreq.Model, true, 0, reqBody)
+    req := cfg.newOpenAIRequest(ctx, base, oreq.Model, reqBody)
     req.Header.Set("Accept", "text/event-stream")
-    if apiKey != "" { req.Header.Set("Authorization", "Bearer "+apiKey) }
     start := time.Now()
     if debugEnabled { fmt.Printf("[adapter/openai(stream)] POST %s body=%s\n", req.URL.String(), string(preview(reqBody, 512))) }
-    resp, err := client.Do(req)
-    if err != nil { http.Error(w, "openai stream failed: "+err.Error(), http.StatusBadGateway); return }
+    resp, err := cfg.doUpstream(client, req)
+    if errors.Is(err, errCircuitOpen) { writeAnthropicStreamError(w, http.StatusServiceUnavailable, "overloaded_error", err.Error()); return }
+    if err != nil { writeAnthropicStreamError(w, http.StatusBadGateway, "api_error", "openai stream failed: "+err.Error()); return }
     defer resp.Body.Close()
+    defer cfg.transcribeStream(ctx, "openai", oreq.Model, resp)()
     if debugEnabled { fmt.Printf("[adapter/openai(stream)] status=%d in %s\n", resp.StatusCode, time.Since(start)) }
     if resp.StatusCode >= 300 {
         body, _ := io.ReadAll(io.LimitReader(resp.Body, 8192))
-        http.Error(w, fmt.Sprintf("openai error %d: %s", resp.StatusCode, string(body)), http.StatusBadGateway)
+        errType := upstreamErrorType(resp.StatusCode, body)
+        writeAnthropicStreamError(w, statusForAnthropicError(errType, cfg), errType, fmt.Sprintf("openai error %d: %s", resp.StatusCode, string(body)))
         return
     }
-    w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
-    w.Header().Set("Cache-Control", "no-cache")
-    w.Header().Set("Connection", "keep-alive")
+    // Some gateways answer a stream request with plain JSON; map it once and replay it as SSE.
+    var aresp *adapter.AnthropicMessageResponse
+    if ct := strings.ToLower(resp.Header.Get("Content-Type")); ct != "" && !strings.HasPrefix(ct, "text/event-stream") {
+        raw, err := io.ReadAll(resp.Body)
+        if err != nil { writeAnthropicStreamError(w, http.StatusBadGateway, "api_error", "openai read failed: "+err.Error()); return }
+        var oresp adapter.OpenAIChatResponse
+        if err := json.Unmarshal(raw, &oresp); err != nil { writeAnthropicStreamError(w, http.StatusBadGateway, "api_error", "invalid openai response"); return }
+        a, err := adapter.OpenAIToAnthropic(oresp, areq.Model, cfg.adapterOptions())
+        if err != nil { writeAnthropicStreamError(w, http.StatusBadGateway, "api_error", "mapping error: "+err.Error()); return }
+        if debugEnabled { fmt.Printf("[adapter/openai(stream)] upstream returned %q, replaying non-stream response as SSE\n", resp.Header.Get("Content-Type")) }
+        aresp = &a
+    }
+    sseHeaders(w)
     flusher, ok := w.(http.Flusher)
-    if !ok { http.Error(w, "streaming unsupported", http.StatusInternalServerError); return }
-    _ = adapter.ConvertOpenAIStreamToAnthropic(ctx, areq.Model, resp.Body, func(event string, payload interface{}) {
+    if !ok { writeAnthropicError(w, http.StatusInternalServerError, "api_error", "streaming unsupported"); return }
+    sw := newSSEStream(w, flusher, cancel)
+    sw.eventIDs = cfg.AnthropicSSECompat
+    enc := func(event string, payload interface{}) {
+        if cfg.AnthropicSSECompat { event = anthropicEventName(event) }
         if logEvents && debugEnabled {
             if payload != nil { pb, _ := json.Marshal(payload); fmt.Printf("[adapter/sse->anthropic] event=%s payload=%s\n", event, string(preview(pb, 256))) } else { fmt.Printf("[adapter/sse->anthropic] event=%s\n", event) }
         }
-        fmt.Fprintf(w, "event: %s\n", event)
-        if payload != nil {
-            b, _ := json.Marshal(payload)
-            fmt.Fprintf(w, "data: %s\n\n", string(b))
-        } else {
-            fmt.Fprintf(w, "data: {}\n\n")
-        }
-        flusher.Flush()
-    })
+        sw.writeEvent(event, payload)
+    }
+    if aresp != nil { adapter.ReplayAnthropicResponse(*aresp, enc); return }
+    stopPing := sw.keepAlive(ctx, cfg.pingInterval(), anthropicPingFrame)
+    defer stopPing()
+    _ = adapter.ConvertOpenAIStreamToAnthropic(ctx, areq.Model, resp.Body, enc, cfg.adapterOptions())
 }
 
-func proxyToAnthropicOnce(w http.ResponseWriter, ctx context.Context, client *http.Client, base string, cfg Config, areq adapter.AnthropicMessageRequest, openaiModel string) {
+func proxyToAnthropicOnce(w http.ResponseWriter, ctx context.Context, client *http.Client, base string, cfg Config, areq adapter.AnthropicMessageRequest, openaiModel string, opts adapter.Options) {
     body, _ := json.Marshal(areq)
+    cfg.transcribe(ctx, "request", "anthropic", areq.Model, areq.Stream, 0, body)
     req, _ := http.NewRequestWithContext(ctx, http.MethodPost, base+"/v1/messages", bytes.NewReader(body))
+    setUpstreamRequestID(req)
+    setForwardedHeaders(req)
     req.Header.Set("Content-Type", "application/json")
     if cfg.AnthropicAPIKey != "" { req.Header.Set("x-api-key", cfg.AnthropicAPIKey) }
     if cfg.AnthropicVersion != "" { req.Header.Set("anthropic-version", cfg.AnthropicVersion) } else { req.Header.Set("anthropic-version", "2023-06-01") }
-    resp, err := client.Do(req)
-    if err != nil { http.Error(w, "anthropic request failed: "+err.Error(), http.StatusBadGateway); return }
+    resp, err := cfg.doUpstream(client, req)
+    if errors.Is(err, errCircuitOpen) { writeOpenAIError(w, http.StatusServiceUnavailable, adapter.OpenAIErrorType("overloaded_error"), err.Error()); return }
+    if err != nil { writeOpenAIError(w, http.StatusBadGateway, "server_error", "anthropic request failed: "+err.Error()); return }
     defer resp.Body.Close()
     if resp.StatusCode >= 300 {
         b, _ := io.ReadAll(io.LimitReader(resp.Body, 8192))
-        http.Error(w, fmt.Sprintf("anthropic error %d: %s", resp.StatusCode, string(b)), http.StatusBadGateway)
+        cfg.transcribe(ctx, "response", "anthropic", areq.Model, false, resp.StatusCode, b)
+        errType := upstreamErrorType(resp.StatusCode, b)
+        writeOpenAIError(w, statusForAnthropicError(errType, cfg), adapter.OpenAIErrorType(errType), fmt.Sprintf("anthropic error %d: %s", resp.StatusCode, string(b)))
         return
     }
+    raw, err := io.ReadAll(resp.Body)
+    if err != nil { writeOpenAIError(w, http.StatusBadGateway, "server_error", "anthropic read failed: "+err.Error()); return }
+    cfg.transcribe(ctx, "response", "anthropic", areq.Model, false, resp.StatusCode, raw)
     var aresp adapter.AnthropicMessageResponse
-    if err := json.NewDecoder(resp.Body).Decode(&aresp); err != nil { http.Error(w, "invalid anthropic response", http.StatusBadGateway); return }
-    oresp, err := adapter.AnthropicToOpenAIResponse(aresp, openaiModel)
-    if err != nil { http.Error(w, "mapping error: "+err.Error(), http.StatusBadGateway); return }
+    if err := json.Unmarshal(raw, &aresp); err != nil { mappingError(w, cfg, writeOpenAIError, "server_error", "invalid anthropic response", raw); return }
+    oresp, err := adapter.AnthropicToOpenAIResponse(aresp, openaiModel, opts)
+    if err != nil { mappingError(w, cfg, writeOpenAIError, "server_error", "mapping error: "+err.Error(), raw); return }
+    if cfg.OpenAIResponseTransform != nil { cfg.OpenAIResponseTransform(&oresp) }
     writeJSON(w, http.StatusOK, oresp)
 }
 
-func proxyToAnthropicStream(w http.ResponseWriter, ctx context.Context, client *http.Client, base string, cfg Config, areq adapter.AnthropicMessageRequest, openaiModel string) {
+func proxyToAnthropicStream(w http.ResponseWriter, ctx context.Context, client *http.Client, base string, cfg Config, areq adapter.AnthropicMessageRequest, openaiModel string, opts adapter.Options) {
+    // Cancelled on the first failed client write so the upstream stream stops promptly.
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
     areq.Stream = true
     body, _ := json.Marshal(areq)
+    cfg.transcribe(ctx, "request", "anthropic", areq.Model, areq.Stream, 0, body)
     req, _ := http.NewRequestWithContext(ctx, http.MethodPost, base+"/v1/messages", bytes.NewReader(body))
+    setUpstreamRequestID(req)
+    setForwardedHeaders(req)
     req.Header.Set("Content-Type", "application/json")
     if cfg.AnthropicAPIKey != "" { req.Header.Set("x-api-key", cfg.AnthropicAPIKey) }
     if cfg.AnthropicVersion != "" { req.Header.Set("anthropic-version", cfg.AnthropicVersion) } else { req.Header.Set("anthropic-version", "2023-06-01") }
-    resp, err := client.Do(req)
-    if err != nil { http.Error(w, "anthropic stream failed: "+err.Error(), http.StatusBadGateway); return }
+    resp, err := cfg.doUpstream(client, req)
+    if errors.Is(err, errCircuitOpen) { writeOpenAIStreamError(w, http.StatusServiceUnavailable, adapter.OpenAIErrorType("overloaded_error"), err.Error()); return }
+    if err != nil { writeOpenAIStreamError(w, http.StatusBadGateway, "server_error", "anthropic stream failed: "+err.Error()); return }
     defer resp.Body.Close()
+    defer cfg.transcribeStream(ctx, "anthropic", areq.Model, resp)()
     if resp.StatusCode >= 300 {
         b, _ := io.ReadAll(io.LimitReader(resp.Body, 8192))
-        http.Error(w, fmt.Sprintf("anthropic error %d: %s", resp.StatusCode, string(b)), http.StatusBadGateway)
+        errType := upstreamErrorType(resp.StatusCode, b)
+        writeOpenAIStreamError(w, statusForAnthropicError(errType, cfg), adapter.OpenAIErrorType(errType), fmt.Sprintf("anthropic error %d: %s", resp.StatusCode, string(b)))
         return
     }
-    w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
-    w.Header().Set("Cache-Control", "no-cache")
-    w.Header().Set("Connection", "keep-alive")
+    sseHeaders(w)
     flusher, ok := w.(http.Flusher)
-    if !ok { http.Error(w, "streaming unsupported", http.StatusInternalServerError); return }
+    if !ok { writeOpenAIError(w, http.StatusInternalServerError, "server_error", "streaming unsupported"); return }
+    sw := newSSEStream(w, flusher, cancel)
+    stopPing := sw.keepAlive(ctx, cfg.pingInterval(), openAIPingFrame)
     _ = adapter.ConvertAnthropicStreamToOpenAI(ctx, openaiModel, resp.Body, func(chunk map[string]interface{}) {
         if logEvents && debugEnabled { b, _ := json.Marshal(chunk); fmt.Printf("[adapter/sse->openai] chunk=%s\n", string(preview(b, 256))) }
-        b, _ := json.Marshal(chunk)
-        fmt.Fprintf(w, "data: %s\n\n", string(b))
-        flusher.Flush()
-    })
-    fmt.Fprintf(w, "data: [DONE]\n\n")
-    flusher.Flush()
+        sw.writeEvent("", chunk)
+    }, opts)
+    stopPing()
+    sw.writeFrame("data: [DONE]\n\n")
 }
 
 func debugNoStream(r *http.Request) bool {
@@ -242,6 +601,18 @@ func debugNoStream(r *http.Request) bool {
     return false
 }
 
+// accessLogEntry is the JSON form of a Logging line.
+type accessLogEntry struct {
+    Time       string `json:"time"`
+    RemoteAddr string `json:"remote_addr"`
+    Method     string `json:"method"`
+    Path       string `json:"path"`
+    Status     int    `json:"status"`
+    Bytes      int    `json:"bytes"`
+    DurationMS int64  `json:"duration_ms"`
+    RequestID  string `json:"request_id,omitempty"`
+}
+
 // Optional small logging middleware (used by cmd/adapter)
 func Logging(next http.Handler) http.Handler {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -249,6 +620,13 @@ func Logging(next http.Handler) http.Handler {
         sw := &statusWriter{ResponseWriter: w, status: 200}
         next.ServeHTTP(sw, r)
         dur := time.Since(start)
-        fmt.Printf("%s %s %s %d %dB %s\n", r.RemoteAddr, r.Method, r.URL.Path, sw.status, sw.written, strconv.FormatInt(dur.Milliseconds(), 10)+"ms")
+        if logJSON {
+            b, _ := json.Marshal(accessLogEntry{Time: start.UTC().Format(time.RFC3339Nano), RemoteAddr: r.RemoteAddr, Method: r.Method, Path: r.URL.Path, Status: sw.status, Bytes: sw.written, DurationMS: dur.Milliseconds(), RequestID: requestIDFrom(r.Context())})
+            fmt.Println(string(b))
+            return
+        }
+        line := fmt.Sprintf("%s %s %s %d %dB %s", r.RemoteAddr, r.Method, r.URL.Path, sw.status, sw.written, strconv.FormatInt(dur.Milliseconds(), 10)+"ms")
+        if id := requestIDFrom(r.Context()); id != "" { line += " id=" + id }
+        fmt.Println(line)
     })
 }
@@ -0,0 +1,33 @@
+package adapterhttp
+
+import (
+    "context"
+    "net/http"
+)
+
+// defaultForwardHeaders are the caller headers passed to the upstream when
+// Config.ForwardHeaders is nil: beta feature flags and OpenAI org/project scoping.
+var defaultForwardHeaders = []string{"anthropic-beta", "OpenAI-Organization", "OpenAI-Project"}
+
+type forwardHeadersKey struct{}
+
+// withForwardedHeaders returns r's context carrying the allowlisted caller headers.
+func withForwardedHeaders(r *http.Request, cfg Config) context.Context {
+    names := cfg.ForwardHeaders
+    if names == nil { names = defaultForwardHeaders }
+    h := http.Header{}
+    for _, name := range names {
+        for _, v := range r.Header.Values(name) { h.Add(name, v) }
+    }
+    if len(h) == 0 { return r.Context() }
+    return context.WithValue(r.Context(), forwardHeadersKey{}, h)
+}
+
+// setForwardedHeaders copies the caller headers stored in req's context onto req.
+func setForwardedHeaders(req *http.Request) {
+    h, _ := req.Context().Value(forwardHeadersKey{}).(http.Header)
+    for name, vals := range h {
+        req.Header.Del(name)
+        for _, v := range vals { req.Header.Add(name, v) }
+    }
+}
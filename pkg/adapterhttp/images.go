@@ -0,0 +1,71 @@
+package adapterhttp
+
+import (
+    "bytes"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "image"
+    _ "image/jpeg" // register decoders for DecodeConfig
+    _ "image/png"
+    "strings"
+
+    "claude-openai-adapter/pkg/adapter"
+)
+
+// checkImagePixels walks decoded request content and rejects inline images
+// whose width*height exceeds max. Both shapes are recognised: Anthropic image
+// blocks with a base64 source and OpenAI image_url parts with a data: URL.
+// Remote URLs and images that don't decode as PNG/JPEG are not checked.
+func checkImagePixels(content interface{}, max int) error {
+    if max <= 0 { return nil }
+    switch v := content.(type) {
+    case []interface{}:
+        for _, it := range v {
+            if err := checkImagePixels(it, max); err != nil { return err }
+        }
+    case map[string]interface{}:
+        if data, ok := inlineImageData(v); ok {
+            cfg, _, err := image.DecodeConfig(base64.NewDecoder(base64.StdEncoding, strings.NewReader(data)))
+            if err != nil { return nil }
+            if px := cfg.Width * cfg.Height; px > max {
+                return fmt.Errorf("image is %dx%d (%d pixels), exceeding the limit of %d pixels", cfg.Width, cfg.Height, px, max)
+            }
+            return nil
+        }
+        // tool_result blocks carry nested content
+        if inner, ok := v["content"]; ok { return checkImagePixels(inner, max) }
+    }
+    return nil
+}
+
+// inlineImageData returns the base64 payload of an inline image block or part.
+func inlineImageData(mp map[string]interface{}) (string, bool) {
+    switch mp["type"] {
+    case "image":
+        src, _ := mp["source"].(map[string]interface{})
+        if src["type"] != "base64" { return "", false }
+        data, ok := src["data"].(string)
+        return data, ok
+    case "image_url":
+        iu, _ := mp["image_url"].(map[string]interface{})
+        url, _ := iu["url"].(string)
+        if !strings.HasPrefix(url, "data:") { return "", false }
+        i := strings.Index(url, ";base64,")
+        if i < 0 { return "", false }
+        return url[i+len(";base64,"):], true
+    }
+    return "", false
+}
+
+// checkAnthropicImages applies checkImagePixels to every message of an Anthropic request.
+func checkAnthropicImages(msgs []adapter.AnthropicMsg, max int) error {
+    if max <= 0 { return nil }
+    for _, m := range msgs {
+        var content interface{}
+        d := json.NewDecoder(bytes.NewReader(m.Content))
+        if d.Decode(&content) != nil { continue }
+        if err := checkImagePixels(content, max); err != nil { return err }
+    }
+    return nil
+}
@@ -0,0 +1,47 @@
+package adapterhttp
+
+import (
+    "context"
+    "time"
+)
+
+// errSaturated is the message for requests rejected by a full Limiter.
+const errSaturated = "adapter is at its concurrent upstream request limit; retry shortly"
+
+// Limiter bounds how many upstream calls run at once across every handler that
+// shares it. A call that finds all slots taken waits up to the limiter's wait
+// time for one to free up and is rejected with 503 after that.
+type Limiter struct {
+    slots chan struct{}
+    wait  time.Duration
+}
+
+// NewLimiter allows n concurrent upstream calls; wait is how long a call may
+// queue for a slot (0 rejects at once). n <= 0 returns nil, which never limits.
+func NewLimiter(n int, wait time.Duration) *Limiter {
+    if n <= 0 { return nil }
+    return &Limiter{slots: make(chan struct{}, n), wait: wait}
+}
+
+// acquire takes a slot, reporting false when none frees up in time or ctx ends
+// first. The caller must call release once the upstream call, including any
+// stream it returns, is finished.
+func (l *Limiter) acquire(ctx context.Context) (release func(), ok bool) {
+    if l == nil { return func() {}, true }
+    release = func() { <-l.slots }
+    select {
+    case l.slots <- struct{}{}:
+        return release, true
+    default:
+    }
+    if l.wait <= 0 { return nil, false }
+    t := time.NewTimer(l.wait)
+    defer t.Stop()
+    select {
+    case l.slots <- struct{}{}:
+        return release, true
+    case <-t.C:
+    case <-ctx.Done():
+    }
+    return nil, false
+}
@@ -0,0 +1,94 @@
+package adapterhttp
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// warningKey identifies a lossiness counter. Param is set for unsupported_param
+// so that, e.g., dropped penalties and dropped seeds are counted separately.
+type warningKey struct{ Kind, Param string }
+
+// warningCounts aggregates conversion warnings across requests for /metrics and
+// the periodic summary. The dry-run /v1/convert endpoint doesn't count.
+var warningCounts = struct {
+    sync.Mutex
+    m map[warningKey]int64
+}{m: map[warningKey]int64{}}
+
+func countWarning(kind, detail string) {
+    k := warningKey{Kind: kind}
+    if kind == "unsupported_param" { k.Param = warningParam(detail) }
+    warningCounts.Lock()
+    warningCounts.m[k]++
+    warningCounts.Unlock()
+}
+
+// warningParam extracts the parameter name from an unsupported_param detail,
+// which always starts "<param>[=value] dropped".
+func warningParam(detail string) string {
+    if i := strings.IndexAny(detail, "= "); i >= 0 { return detail[:i] }
+    return detail
+}
+
+// warningSnapshot returns the counters sorted by kind, then param.
+func warningSnapshot() ([]warningKey, map[warningKey]int64) {
+    warningCounts.Lock()
+    counts := make(map[warningKey]int64, len(warningCounts.m))
+    for k, v := range warningCounts.m { counts[k] = v }
+    warningCounts.Unlock()
+    keys := make([]warningKey, 0, len(counts))
+    for k := range counts { keys = append(keys, k) }
+    sort.Slice(keys, func(i, j int) bool {
+        if keys[i].Kind != keys[j].Kind { return keys[i].Kind < keys[j].Kind }
+        return keys[i].Param < keys[j].Param
+    })
+    return keys, counts
+}
+
+// MetricsHandler serves the conversion warning counters in the Prometheus text format.
+func MetricsHandler() http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+        keys, counts := warningSnapshot()
+        w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+        fmt.Fprintln(w, "# HELP adapter_conversion_warnings_total Fields dropped or changed while converting requests and responses.")
+        fmt.Fprintln(w, "# TYPE adapter_conversion_warnings_total counter")
+        for _, k := range keys {
+            labels := fmt.Sprintf("kind=%q", k.Kind)
+            if k.Param != "" { labels += fmt.Sprintf(",param=%q", k.Param) }
+            fmt.Fprintf(w, "adapter_conversion_warnings_total{%s} %d\n", labels, counts[k])
+        }
+    })
+}
+
+// LogMetricsSummary logs the conversion warning counters every interval until
+// ctx is done. Intervals with no new warnings are skipped.
+func LogMetricsSummary(ctx context.Context, interval time.Duration) {
+    t := time.NewTicker(interval)
+    defer t.Stop()
+    var last string
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-t.C:
+        }
+        keys, counts := warningSnapshot()
+        parts := make([]string, 0, len(keys))
+        for _, k := range keys {
+            name := k.Kind
+            if k.Param != "" { name += "/" + k.Param }
+            parts = append(parts, fmt.Sprintf("%s=%d", name, counts[k]))
+        }
+        summary := strings.Join(parts, " ")
+        if summary == "" || summary == last { continue }
+        last = summary
+        log.Printf("[adapter/metrics] conversion warnings since start: %s", summary)
+    }
+}
@@ -0,0 +1,115 @@
+package adapterhttp
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "sync"
+    "time"
+)
+
+const (
+    readyTimeout  = 3 * time.Second // per upstream check
+    readyCacheTTL = 5 * time.Second // how long a result answers probes before the upstreams are checked again
+)
+
+// readyChecker remembers the last upstream check so frequent probes don't
+// turn into upstream traffic.
+type readyChecker struct {
+    cfg    Config
+    client *http.Client
+
+    mu      sync.Mutex
+    checked time.Time
+    err     error
+}
+
+// NewReadyHandler returns a readiness probe: 200 when the upstreams answer a
+// models listing, 503 when one is unreachable, errors, or rejects the API key.
+// Upstreams with an API key configured are checked; with none, the OpenAI one is.
+// An upstream whose cfg.Breaker circuit is open is reported without a check.
+// Results are cached for a few seconds.
+func NewReadyHandler(cfg Config, client *http.Client) http.Handler {
+    if client == nil { client = http.DefaultClient }
+    rc := &readyChecker{cfg: cfg, client: client}
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+        w.Header().Set("Cache-Control", "no-store")
+        if err := rc.check(r.Context()); err != nil {
+            w.WriteHeader(http.StatusServiceUnavailable)
+            _, _ = fmt.Fprintf(w, "not ready: %v\n", err)
+            return
+        }
+        w.WriteHeader(http.StatusOK)
+        _, _ = w.Write([]byte("ok\n"))
+    })
+}
+
+func (rc *readyChecker) check(ctx context.Context) error {
+    rc.mu.Lock()
+    defer rc.mu.Unlock()
+    if !rc.checked.IsZero() && time.Since(rc.checked) < readyCacheTTL { return rc.err }
+    rc.err = rc.probeAll(ctx)
+    rc.checked = time.Now()
+    return rc.err
+}
+
+func (rc *readyChecker) probeAll(ctx context.Context) error {
+    cfg := rc.cfg
+    checkOpenAI := cfg.OpenAIAPIKey != "" || cfg.AnthropicAPIKey == ""
+    if checkOpenAI {
+        if err := rc.probe(ctx, "openai", rc.openAIModelsRequest); err != nil { return err }
+    }
+    if cfg.AnthropicAPIKey != "" {
+        if err := rc.probe(ctx, "anthropic", rc.anthropicModelsRequest); err != nil { return err }
+    }
+    return nil
+}
+
+// probe sends a models listing. A 404 or 405 still counts as ready: the
+// upstream is up, it just doesn't list models (common for self-hosted servers).
+func (rc *readyChecker) probe(ctx context.Context, api string, build func(context.Context) (*http.Request, error)) error {
+    ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+    defer cancel()
+    req, err := build(ctx)
+    if err != nil { return fmt.Errorf("%s: %v", api, err) }
+    if rc.cfg.Breaker.isOpen(req.URL.Host) { return fmt.Errorf("%s %v", api, errCircuitOpen) }
+    resp, err := rc.client.Do(req)
+    if err != nil { return fmt.Errorf("%s upstream unreachable: %v", api, err) }
+    _, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+    resp.Body.Close()
+    switch {
+    case resp.StatusCode < 300, resp.StatusCode == http.StatusNotFound, resp.StatusCode == http.StatusMethodNotAllowed:
+        return nil
+    case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+        return fmt.Errorf("%s upstream rejected the API key (status %d)", api, resp.StatusCode)
+    }
+    return fmt.Errorf("%s upstream returned status %d", api, resp.StatusCode)
+}
+
+func (rc *readyChecker) openAIModelsRequest(ctx context.Context) (*http.Request, error) {
+    cfg := rc.cfg
+    u := trimRightSlash(cfg.OpenAIBaseURL) + "/v1/models"
+    if cfg.AzureOpenAI {
+        version := cfg.AzureAPIVersion
+        if version == "" { version = defaultAzureAPIVersion }
+        u = trimRightSlash(cfg.OpenAIBaseURL) + "/openai/models?api-version=" + url.QueryEscape(version)
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+    if err != nil { return nil, err }
+    if cfg.OpenAIAPIKey != "" {
+        if cfg.AzureOpenAI { req.Header.Set("api-key", cfg.OpenAIAPIKey) } else { req.Header.Set("Authorization", "Bearer "+cfg.OpenAIAPIKey) }
+    }
+    return req, nil
+}
+
+func (rc *readyChecker) anthropicModelsRequest(ctx context.Context) (*http.Request, error) {
+    cfg := rc.cfg
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, trimRightSlash(cfg.AnthropicBaseURL)+"/v1/models", nil)
+    if err != nil { return nil, err }
+    req.Header.Set("x-api-key", cfg.AnthropicAPIKey)
+    if cfg.AnthropicVersion != "" { req.Header.Set("anthropic-version", cfg.AnthropicVersion) } else { req.Header.Set("anthropic-version", "2023-06-01") }
+    return req, nil
+}
@@ -0,0 +1,42 @@
+package adapterhttp
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "net/http"
+    "strings"
+)
+
+const requestIDHeader = "X-Request-Id"
+
+type requestIDKey struct{}
+
+// RequestID assigns every request an id, taken from X-Request-Id when the caller
+// sends one and generated otherwise. The id is echoed on the response, forwarded
+// on upstream requests, and printed by Logging when it wraps the same request.
+func RequestID(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        id := strings.TrimSpace(r.Header.Get(requestIDHeader))
+        if id == "" || len(id) > 128 { id = newRequestID() }
+        w.Header().Set(requestIDHeader, id)
+        next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+    })
+}
+
+// requestIDFrom returns the id RequestID stored in ctx, or "".
+func requestIDFrom(ctx context.Context) string {
+    id, _ := ctx.Value(requestIDKey{}).(string)
+    return id
+}
+
+// setUpstreamRequestID copies the request id from ctx onto an upstream request.
+func setUpstreamRequestID(req *http.Request) {
+    if id := requestIDFrom(req.Context()); id != "" { req.Header.Set(requestIDHeader, id) }
+}
+
+func newRequestID() string {
+    var b [12]byte
+    _, _ = rand.Read(b[:])
+    return "req_" + hex.EncodeToString(b[:])
+}
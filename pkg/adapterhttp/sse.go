@@ -0,0 +1,141 @@
+package adapterhttp
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+const defaultSSEPingInterval = 15 * time.Second
+
+// sseStream serializes writes to a streaming response so keepalive pings
+// never land in the middle of an event frame.
+// A failed write means the client is gone, so it cancels the upstream request
+// instead of waiting for the request context to notice.
+type sseStream struct {
+    mu        sync.Mutex
+    w         http.ResponseWriter
+    flusher   http.Flusher
+    cancel    context.CancelFunc
+    failed    bool
+    lastWrite time.Time
+    eventIDs  bool // prefix each frame with an incrementing "id:" line (AnthropicSSECompat)
+    lastID    int
+    buf       bytes.Buffer  // event frames are encoded here, reused across events
+    enc       *json.Encoder // writes into buf
+}
+
+func newSSEStream(w http.ResponseWriter, flusher http.Flusher, cancel context.CancelFunc) *sseStream {
+    s := &sseStream{w: w, flusher: flusher, cancel: cancel, lastWrite: time.Now()}
+    s.enc = json.NewEncoder(&s.buf)
+    return s
+}
+
+// maxKeptFrame keeps the odd huge frame's buffer from staying allocated for the rest of the stream.
+const maxKeptFrame = 64 << 10
+
+// writeFrame writes one pre-built SSE frame (a ping, [DONE], an error) and flushes it.
+func (s *sseStream) writeFrame(frame string) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.failed { return }
+    err := s.writeID()
+    if err == nil { _, err = io.WriteString(s.w, frame) }
+    s.finish(err)
+}
+
+// writeEvent encodes payload as one frame, "event: <event>" (when event is
+// non-empty) then "data: <json>", into the stream's buffer and writes it. A nil
+// payload is sent as {}.
+func (s *sseStream) writeEvent(event string, payload interface{}) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.failed { return }
+    s.buf.Reset()
+    if event != "" { s.buf.WriteString("event: "); s.buf.WriteString(event); s.buf.WriteByte('\n') }
+    s.buf.WriteString("data: ")
+    mark := s.buf.Len()
+    // Encode ends the JSON with the newline that closes the data line
+    if payload == nil || s.enc.Encode(payload) != nil { s.buf.Truncate(mark); s.buf.WriteString("{}\n") }
+    s.buf.WriteByte('\n')
+    err := s.writeID()
+    if err == nil { _, err = s.w.Write(s.buf.Bytes()) }
+    if s.buf.Cap() > maxKeptFrame { s.buf = bytes.Buffer{} }
+    s.finish(err)
+}
+
+// writeID writes the frame's "id:" line when event IDs are on. Callers hold s.mu.
+func (s *sseStream) writeID() error {
+    if !s.eventIDs { return nil }
+    s.lastID++
+    var idb [24]byte
+    _, err := s.w.Write(append(strconv.AppendInt(append(idb[:0], "id: "...), int64(s.lastID), 10), '\n'))
+    return err
+}
+
+// finish flushes a written frame. After the first write error every further
+// frame is dropped. Callers hold s.mu.
+func (s *sseStream) finish(err error) {
+    if err != nil {
+        s.failed = true
+        if debugEnabled { fmt.Printf("[adapter/sse] client write failed, cancelling upstream: %v\n", err) }
+        s.cancel()
+        return
+    }
+    s.flusher.Flush()
+    s.lastWrite = time.Now()
+}
+
+// keepAlive writes ping whenever the stream has been idle for interval, until
+// ctx is done or the returned stop func is called. A non-positive interval disables pings.
+func (s *sseStream) keepAlive(ctx context.Context, interval time.Duration, ping string) (stop func()) {
+    if interval <= 0 { return func() {} }
+    done, exited := make(chan struct{}), make(chan struct{})
+    var once sync.Once
+    go func() {
+        defer close(exited)
+        t := time.NewTicker(interval)
+        defer t.Stop()
+        for {
+            select {
+            case <-ctx.Done(): return
+            case <-done: return
+            case <-t.C:
+                s.mu.Lock()
+                idle := time.Since(s.lastWrite) >= interval
+                s.mu.Unlock()
+                if idle { s.writeFrame(ping) }
+            }
+        }
+    }()
+    // stop waits for the pinger to exit so nothing writes after the handler returns.
+    return func() { once.Do(func() { close(done) }); <-exited }
+}
+
+// pingInterval returns the configured keepalive interval: zero uses the default, negative disables.
+func (cfg Config) pingInterval() time.Duration {
+    if cfg.SSEPingInterval == 0 { return defaultSSEPingInterval }
+    return cfg.SSEPingInterval
+}
+
+const (
+    anthropicPingFrame = "event: ping\ndata: {\"type\":\"ping\"}\n\n"
+    openAIPingFrame    = ": ping\n\n"
+)
+
+// anthropicEvents are the SSE event names of the Anthropic Messages stream.
+var anthropicEvents = map[string]bool{"message_start": true, "content_block_start": true, "content_block_delta": true, "content_block_stop": true, "message_delta": true, "message_stop": true, "ping": true, "error": true}
+
+// anthropicEventName returns event in the spec's exact spelling when it is a
+// known Anthropic event (matching case-insensitively), and event unchanged otherwise.
+func anthropicEventName(event string) string {
+    if e := strings.ToLower(strings.TrimSpace(event)); anthropicEvents[e] { return e }
+    return event
+}
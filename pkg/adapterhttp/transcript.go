@@ -0,0 +1,145 @@
+package adapterhttp
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "sync"
+    "time"
+)
+
+const (
+    transcriptBuffer    = 1024    // records that may wait for the writer before new ones are dropped
+    transcriptStreamMax = 1 << 20 // bytes of one upstream stream kept in its record; the rest is cut
+)
+
+// Transcript records every upstream request the adapter sends and the response
+// it gets back as JSON lines, for replaying and diffing conversions offline.
+// Records are written by a background goroutine so a slow disk never holds up
+// a request; when the queue is full, records are dropped and counted.
+type Transcript struct {
+    w       io.Writer
+    ch      chan transcriptRecord
+    done    chan struct{}
+    mu      sync.Mutex
+    closed  bool
+    dropped int
+}
+
+// transcriptRecord is one JSONL line. Body is the upstream JSON as sent or
+// received; stream responses are kept as their raw SSE text.
+type transcriptRecord struct {
+    Time      string          `json:"time"`
+    RequestID string          `json:"request_id,omitempty"`
+    Direction string          `json:"direction"` // "request" (adapter -> upstream) or "response" (upstream -> adapter)
+    API       string          `json:"api"`       // upstream API: "openai" or "anthropic"
+    Model     string          `json:"model"`
+    Stream    bool            `json:"stream,omitempty"`
+    Status    int             `json:"status,omitempty"`
+    Body      json.RawMessage `json:"body"`
+    Truncated bool            `json:"truncated,omitempty"` // the stream passed transcriptStreamMax
+}
+
+// NewTranscript starts a transcript writing to w. Close it to flush pending records.
+func NewTranscript(w io.Writer) *Transcript {
+    t := &Transcript{w: w, ch: make(chan transcriptRecord, transcriptBuffer), done: make(chan struct{})}
+    go t.run()
+    return t
+}
+
+func (t *Transcript) run() {
+    defer close(t.done)
+    for rec := range t.ch {
+        b, err := json.Marshal(rec)
+        if err != nil { continue }
+        _, _ = t.w.Write(append(b, '\n'))
+    }
+}
+
+func (t *Transcript) record(rec transcriptRecord) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if t.closed { return }
+    select {
+    case t.ch <- rec:
+    default:
+        t.dropped++
+    }
+}
+
+// Close writes the queued records and stops the writer. Later records are ignored.
+func (t *Transcript) Close() error {
+    t.mu.Lock()
+    if t.closed { t.mu.Unlock(); return nil }
+    t.closed = true
+    close(t.ch)
+    dropped := t.dropped
+    t.mu.Unlock()
+    <-t.done
+    if dropped > 0 { fmt.Printf("[adapter/transcript] %d records dropped: writer could not keep up\n", dropped) }
+    return nil
+}
+
+// transcribe queues body for cfg.Transcript, redacted unless cfg.TranscriptRaw is set.
+func (cfg Config) transcribe(ctx context.Context, direction, api, model string, stream bool, status int, body []byte) {
+    if cfg.Transcript == nil { return }
+    if !cfg.TranscriptRaw { body = redactJSON(body) }
+    raw := json.RawMessage(body)
+    if !json.Valid(body) { raw, _ = json.Marshal(string(body)) }
+    cfg.Transcript.record(transcriptRecord{Time: time.Now().UTC().Format(time.RFC3339Nano), RequestID: requestIDFrom(ctx), Direction: direction, API: api, Model: model, Stream: stream, Status: status, Body: raw})
+}
+
+// transcribeStream tees up to transcriptStreamMax bytes of resp.Body so that the
+// returned func can record the upstream stream once it has been consumed. Unless
+// cfg.TranscriptRaw is set each SSE data payload is redacted on its own, keeping
+// the event framing readable.
+func (cfg Config) transcribeStream(ctx context.Context, api, model string, resp *http.Response) (flush func()) {
+    if cfg.Transcript == nil { return func() {} }
+    buf := &cappedBuffer{max: transcriptStreamMax}
+    resp.Body = struct{ io.Reader; io.Closer }{io.TeeReader(resp.Body, buf), resp.Body}
+    return func() {
+        body := buf.Bytes()
+        // a gateway may answer a stream request with plain JSON
+        if json.Valid(body) { cfg.transcribe(ctx, "response", api, model, true, resp.StatusCode, body); return }
+        if !cfg.TranscriptRaw { body = redactSSE(body) }
+        raw, _ := json.Marshal(string(body))
+        cfg.Transcript.record(transcriptRecord{Time: time.Now().UTC().Format(time.RFC3339Nano), RequestID: requestIDFrom(ctx), Direction: "response", API: api, Model: model, Stream: true, Status: resp.StatusCode, Body: raw, Truncated: buf.truncated})
+    }
+}
+
+// cappedBuffer keeps the first max bytes written to it and drops the rest.
+type cappedBuffer struct {
+    bytes.Buffer
+    max       int
+    truncated bool
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+    if room := c.max - c.Len(); len(p) > room {
+        c.truncated = true
+        if room > 0 { c.Buffer.Write(p[:room]) }
+        return len(p), nil
+    }
+    return c.Buffer.Write(p)
+}
+
+// redactSSE redacts the JSON payload of each data line in an SSE stream; event
+// lines, comments and [DONE] markers are kept. A payload that isn't complete JSON,
+// such as one cut by the size cap, is redacted wholesale.
+func redactSSE(b []byte) []byte {
+    var out bytes.Buffer
+    for _, ln := range bytes.SplitAfter(b, []byte("\n")) {
+        line := bytes.TrimRight(ln, "\r\n")
+        payload, ok := bytes.CutPrefix(line, []byte("data:"))
+        if !ok { out.Write(ln); continue }
+        payload = bytes.TrimSpace(payload)
+        if string(payload) != "[DONE]" { payload = redactJSON(payload) }
+        out.WriteString("data: ")
+        out.Write(payload)
+        out.Write(ln[len(line):])
+    }
+    return out.Bytes()
+}
@@ -0,0 +1,60 @@
+package adapterhttp
+
+import (
+    "net/http"
+    "net/url"
+    "runtime"
+    "runtime/debug"
+)
+
+// BuildInfo identifies the running build. The binary fills it from -ldflags;
+// empty Commit and BuildDate fall back to the VCS stamp Go embeds at build time.
+type BuildInfo struct {
+    Version   string `json:"version"`
+    Commit    string `json:"commit,omitempty"`
+    BuildDate string `json:"build_date,omitempty"`
+}
+
+// versionResponse is the body served by NewVersionHandler.
+type versionResponse struct {
+    BuildInfo
+    GoVersion string            `json:"go_version"`
+    Upstreams map[string]string `json:"upstreams"`
+}
+
+// NewVersionHandler serves info plus the configured upstream base URLs as JSON,
+// to confirm which build and upstreams a deployment runs. Credentials embedded
+// in the URLs are redacted; API keys are never included.
+func NewVersionHandler(cfg Config, info BuildInfo) http.Handler {
+    if bi, ok := debug.ReadBuildInfo(); ok {
+        for _, s := range bi.Settings {
+            switch {
+            case s.Key == "vcs.revision" && info.Commit == "": info.Commit = s.Value
+            case s.Key == "vcs.time" && info.BuildDate == "": info.BuildDate = s.Value
+            }
+        }
+    }
+    if info.Version == "" { info.Version = "dev" }
+    body := versionResponse{BuildInfo: info, GoVersion: runtime.Version(), Upstreams: map[string]string{
+        "openai":    redactURL(cfg.OpenAIBaseURL),
+        "anthropic": redactURL(cfg.AnthropicBaseURL),
+    }}
+    return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { writeJSON(w, http.StatusOK, body) })
+}
+
+// redactURL hides the password in a URL's userinfo and its query values, where
+// gateways sometimes take keys. Unparseable URLs are hidden entirely.
+func redactURL(raw string) string {
+    if raw == "" { return "" }
+    u, err := url.Parse(raw)
+    if err != nil { return "[redacted]" }
+    if u.User != nil {
+        if _, ok := u.User.Password(); ok { u.User = url.UserPassword(u.User.Username(), "redacted") }
+    }
+    if u.RawQuery != "" {
+        q := u.Query()
+        for k := range q { q.Set(k, "redacted") }
+        u.RawQuery = q.Encode()
+    }
+    return u.String()
+}
@@ -1,30 +1,56 @@
 package logging
 
 import (
+    "compress/gzip"
     "fmt"
     "io"
     "os"
     "path/filepath"
+    "sort"
+    "strconv"
     "strings"
     "sync"
     "time"
 )
 
 // RotatingWriter writes logs to a daily file with optional size-based rollover.
-// Files are named: <base>-YYYY-MM-DD[-N].log (UTC date).
+// Files are named: <base>-YYYY-MM-DD[-N].log (UTC date unless a Location is set).
 type RotatingWriter struct {
     basePath string
     maxBytes int64
+    opts     RotatingOptions
 
     mu       sync.Mutex
     curDate  string
     curIndex int
     f        *os.File
+    curPath  string // path of f, kept after Close
     size     int64
+    closed   bool
+
+    compressing sync.WaitGroup  // background gzip of files rotated away from
+    gzipping    map[string]bool // files being gzipped; prune waits until none are
+}
+
+// RotatingOptions configures NewRotatingWriterOptions. Zero values disable each limit.
+type RotatingOptions struct {
+    MaxBytes      int64            // size at which a day's file rolls over to -N
+    RetentionDays int              // delete files dated more than this many days ago
+    MaxTotalBytes int64            // delete the oldest files until all logs fit in this many bytes
+    Location      *time.Location   // zone of the date boundary and file names; nil means UTC
+    Now           func() time.Time // clock, for tests; nil means time.Now
+    Symlink       bool             // keep basePath as a symlink to the current file instead of a pointer file
+    Compress      bool             // gzip each file to <name>.gz once writing moves on to the next one, and any left uncompressed at startup
 }
 
-func NewRotatingWriter(path string, maxBytes int64) (io.Writer, error) {
-    rw := &RotatingWriter{basePath: path, maxBytes: maxBytes}
+func NewRotatingWriter(path string, maxBytes int64) (*RotatingWriter, error) {
+    return NewRotatingWriterOptions(path, RotatingOptions{MaxBytes: maxBytes})
+}
+
+func NewRotatingWriterOptions(path string, opts RotatingOptions) (*RotatingWriter, error) {
+    rw := &RotatingWriter{basePath: path, maxBytes: opts.MaxBytes, opts: opts, gzipping: map[string]bool{}}
+    rw.mu.Lock() // compression started by the first open takes it to prune
+    defer rw.mu.Unlock()
     if err := rw.rotateIfNeeded(0); err != nil { return nil, err }
     return rw, nil
 }
@@ -32,18 +58,54 @@ func NewRotatingWriter(path string, maxBytes int64) (io.Writer, error) {
 func (w *RotatingWriter) Write(p []byte) (int, error) {
     w.mu.Lock()
     defer w.mu.Unlock()
+    if w.closed { return 0, os.ErrClosed }
     if err := w.rotateIfNeeded(len(p)); err != nil { return 0, err }
     n, err := w.f.Write(p)
     if err == nil { w.size += int64(n) }
     return n, err
 }
 
+// now is the current time in the configured zone.
+func (w *RotatingWriter) now() time.Time {
+    now := time.Now
+    if w.opts.Now != nil { now = w.opts.Now }
+    loc := w.opts.Location
+    if loc == nil { loc = time.UTC }
+    return now().In(loc)
+}
+
+// Close syncs and closes the current file and waits for pending compression.
+// Later writes fail with os.ErrClosed.
+func (w *RotatingWriter) Close() error {
+    w.mu.Lock()
+    if w.closed { w.mu.Unlock(); return nil }
+    w.closed = true
+    var err error
+    if w.f != nil {
+        _ = w.f.Sync()
+        err = w.f.Close()
+        w.f = nil
+    }
+    // compression takes the lock to prune when it finishes
+    w.mu.Unlock()
+    w.compressing.Wait()
+    return err
+}
+
+var _ io.WriteCloser = (*RotatingWriter)(nil)
+
 func (w *RotatingWriter) rotateIfNeeded(incoming int) error {
-    today := time.Now().UTC().Format("2006-01-02")
+    today := w.now().Format("2006-01-02")
     if w.f == nil || w.curDate != today {
+        // continue after the day's highest existing file (a restart may find several)
         w.curDate = today
-        w.curIndex = 1
-        return w.openCurrent()
+        w.curIndex = w.lastIndex(today)
+        if err := w.openCurrent(); err != nil { return err }
+        if w.maxBytes > 0 && w.size >= w.maxBytes {
+            w.curIndex++
+            return w.openCurrent()
+        }
+        return nil
     }
     if w.maxBytes > 0 && w.size+int64(incoming) > w.maxBytes {
         w.curIndex++
@@ -53,7 +115,6 @@ func (w *RotatingWriter) rotateIfNeeded(incoming int) error {
 }
 
 func (w *RotatingWriter) openCurrent() error {
-    if w.f != nil { _ = w.f.Close() }
     dir, name := filepath.Split(w.basePath)
     if dir == "" { dir = "." }
     _ = os.MkdirAll(dir, 0o755)
@@ -65,18 +126,144 @@ func (w *RotatingWriter) openCurrent() error {
         filename = fmt.Sprintf("%s-%s-%d%s", base, w.curDate, w.curIndex, ext)
     }
     full := filepath.Join(dir, filename)
+    if w.f != nil { _ = w.f.Close() }
     f, err := os.OpenFile(full, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
     if err != nil { return err }
     st, _ := f.Stat()
-    w.f = f
+    w.f, w.curPath = f, full
     if st != nil { w.size = st.Size() } else { w.size = 0 }
-    // Update pointer file (best-effort): basePath -> current file path
+    w.updateCurrentLink(filename, full)
+    // with files being gzipped, prune once their compressed sizes are known
+    if w.opts.Compress && w.compressRotated(full) { return nil }
+    w.prune(full)
+    return nil
+}
+
+// compressRotated starts gzipping every uncompressed file but current, including
+// any an earlier run left behind, and reports whether compression is in flight.
+func (w *RotatingWriter) compressRotated(current string) bool {
+    for _, f := range w.listLogFiles() {
+        if f.path != current && !strings.HasSuffix(f.path, ".gz") && !w.gzipping[f.path] { w.compress(f.path) }
+    }
+    return len(w.gzipping) > 0
+}
+
+// compress gzips path in the background; the last compression to finish prunes.
+// Callers hold w.mu.
+func (w *RotatingWriter) compress(path string) {
+    w.gzipping[path] = true
+    w.compressing.Add(1)
+    go func() {
+        defer w.compressing.Done()
+        _ = compressFile(path)
+        w.mu.Lock()
+        defer w.mu.Unlock()
+        delete(w.gzipping, path)
+        if len(w.gzipping) == 0 { w.prune(w.curPath) }
+    }()
+}
+
+// updateCurrentLink points basePath at the current file (best-effort): either a
+// relative symlink, swapped in atomically by renaming a temporary link over it,
+// or a pointer file holding the path, for platforms without symlinks.
+func (w *RotatingWriter) updateCurrentLink(filename, full string) {
     tmp := w.basePath + ".tmp"
+    if w.opts.Symlink {
+        _ = os.Remove(tmp)
+        if err := os.Symlink(filename, tmp); err == nil { _ = os.Rename(tmp, w.basePath) }
+        return
+    }
     if ff, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644); err == nil {
         _, _ = fmt.Fprintf(ff, "current log file: %s\n", full)
         _ = ff.Close()
         _ = os.Rename(tmp, w.basePath)
     }
-    return nil
+}
+
+// compressFile replaces path with a gzipped path.gz, keeping the original on error.
+func compressFile(path string) error {
+    in, err := os.Open(path)
+    if err != nil { return err }
+    defer in.Close()
+    tmp := path + ".gz.tmp"
+    out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+    if err != nil { return err }
+    zw := gzip.NewWriter(out)
+    _, err = io.Copy(zw, in)
+    if cerr := zw.Close(); err == nil { err = cerr }
+    if cerr := out.Close(); err == nil { err = cerr }
+    if err == nil { err = os.Rename(tmp, path+".gz") }
+    if err != nil { _ = os.Remove(tmp); return err }
+    return os.Remove(path)
+}
+
+// lastIndex returns the highest rollover index among existing files for date, or 1.
+func (w *RotatingWriter) lastIndex(date string) int {
+    last := 1
+    for _, f := range w.listLogFiles() {
+        if f.date.Format("2006-01-02") == date && f.index > last { last = f.index }
+    }
+    return last
+}
+
+// logFile is a rotated log file found next to the current one.
+type logFile struct {
+    path  string
+    date  time.Time
+    index int
+    size  int64
+}
+
+// prune deletes old log files until RetentionDays and MaxTotalBytes both hold.
+// Files are ordered by the date and index in their names; current is never deleted.
+func (w *RotatingWriter) prune(current string) {
+    if w.opts.RetentionDays <= 0 && w.opts.MaxTotalBytes <= 0 { return }
+    files := w.listLogFiles()
+    var cutoff time.Time
+    if w.opts.RetentionDays > 0 {
+        today, _ := time.Parse("2006-01-02", w.curDate)
+        cutoff = today.AddDate(0, 0, -w.opts.RetentionDays)
+    }
+    var total int64
+    for _, f := range files { total += f.size }
+    for _, f := range files {
+        if f.path == current { continue }
+        expired := !cutoff.IsZero() && f.date.Before(cutoff)
+        overBudget := w.opts.MaxTotalBytes > 0 && total > w.opts.MaxTotalBytes
+        if !expired && !overBudget { continue }
+        if err := os.Remove(f.path); err == nil { total -= f.size }
+    }
+}
+
+// listLogFiles returns the <base>-DATE[-N]<ext>[.gz] files beside basePath, oldest first.
+func (w *RotatingWriter) listLogFiles() []logFile {
+    dir, name := filepath.Split(w.basePath)
+    if dir == "" { dir = "." }
+    ext := filepath.Ext(name)
+    base := strings.TrimSuffix(name, ext)
+    if ext == "" { ext = ".log" }
+    entries, err := os.ReadDir(dir)
+    if err != nil { return nil }
+    var out []logFile
+    for _, e := range entries {
+        n := strings.TrimSuffix(e.Name(), ".gz")
+        if e.IsDir() || !strings.HasPrefix(n, base+"-") || !strings.HasSuffix(n, ext) { continue }
+        stem := strings.TrimSuffix(strings.TrimPrefix(n, base+"-"), ext) // DATE or DATE-N
+        if len(stem) < len("2006-01-02") { continue }
+        date, err := time.Parse("2006-01-02", stem[:10])
+        if err != nil { continue }
+        index := 1
+        if rest := stem[10:]; rest != "" {
+            if index, err = strconv.Atoi(strings.TrimPrefix(rest, "-")); err != nil || !strings.HasPrefix(rest, "-") { continue }
+        }
+        info, err := e.Info()
+        if err != nil { continue }
+        out = append(out, logFile{path: filepath.Join(dir, e.Name()), date: date, index: index, size: info.Size()})
+    }
+    sort.Slice(out, func(i, j int) bool {
+        if !out[i].date.Equal(out[j].date) { return out[i].date.Before(out[j].date) }
+        return out[i].index < out[j].index
+    })
+    return out
 }
 
@@ -0,0 +1,127 @@
+package logging_test
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "runtime"
+    "sort"
+    "strings"
+    "testing"
+    "time"
+
+    apilog "claude-openai-adapter/pkg/logging"
+)
+
+func TestRotatingWriter_Close(t *testing.T) {
+    base := filepath.Join(t.TempDir(), "adapter.log")
+    w, err := apilog.NewRotatingWriter(base, 0)
+    if err != nil { t.Fatalf("NewRotatingWriter: %v", err) }
+    if _, err := w.Write([]byte("last line\n")); err != nil { t.Fatalf("write: %v", err) }
+    if err := w.Close(); err != nil { t.Fatalf("close: %v", err) }
+    if err := w.Close(); err != nil { t.Fatalf("second close: %v", err) }
+    if _, err := w.Write([]byte("too late\n")); !errors.Is(err, os.ErrClosed) { t.Fatalf("write after close: %v", err) }
+
+    ptr, _ := os.ReadFile(base) // pointer file: "current log file: <path>"
+    b, err := os.ReadFile(strings.TrimSpace(strings.TrimPrefix(string(ptr), "current log file:")))
+    if err != nil { t.Fatalf("read: %v", err) }
+    if string(b) != "last line\n" || strings.Contains(string(b), "too late") { t.Fatalf("file content = %q", b) }
+}
+
+func TestRotatingWriter_PrunesOldFiles(t *testing.T) {
+    dir := t.TempDir()
+    day := func(n int) string { return time.Now().UTC().AddDate(0, 0, -n).Format("2006-01-02") }
+    write := func(name string, size int) {
+        if err := os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0o644); err != nil { t.Fatal(err) }
+    }
+    write("adapter-"+day(30)+".log", 10)
+    write("adapter-"+day(30)+"-2.log.gz", 10)
+    write("adapter-"+day(3)+".log", 100)
+    write("adapter-"+day(2)+".log", 100)
+    write("adapter-"+day(1)+".log", 100)
+    write("other-"+day(30)+".log", 10) // not ours
+
+    w, err := apilog.NewRotatingWriterOptions(filepath.Join(dir, "adapter.log"), apilog.RotatingOptions{RetentionDays: 7, MaxTotalBytes: 250})
+    if err != nil { t.Fatalf("NewRotatingWriterOptions: %v", err) }
+    defer w.Close()
+
+    var left []string
+    entries, _ := os.ReadDir(dir)
+    for _, e := range entries { left = append(left, e.Name()) }
+    want := []string{"adapter-" + day(2) + ".log", "adapter-" + day(1) + ".log", "adapter-" + day(0) + ".log", "adapter.log", "other-" + day(30) + ".log"}
+    sort.Strings(want)
+    if strings.Join(left, ",") != strings.Join(want, ",") { t.Fatalf("files after prune:\n got %v\nwant %v", left, want) }
+}
+
+func TestRotatingWriter_ResumesIndexAfterRestart(t *testing.T) {
+    dir := t.TempDir()
+    today := time.Now().UTC().Format("2006-01-02")
+    if err := os.WriteFile(filepath.Join(dir, "adapter-"+today+".log"), make([]byte, 100), 0o644); err != nil { t.Fatal(err) }
+    if err := os.WriteFile(filepath.Join(dir, "adapter-"+today+"-2.log"), make([]byte, 100), 0o644); err != nil { t.Fatal(err) }
+
+    w, err := apilog.NewRotatingWriter(filepath.Join(dir, "adapter.log"), 100)
+    if err != nil { t.Fatalf("NewRotatingWriter: %v", err) }
+    if _, err := w.Write([]byte("after restart\n")); err != nil { t.Fatalf("write: %v", err) }
+    w.Close()
+
+    b, err := os.ReadFile(filepath.Join(dir, "adapter-"+today+"-3.log"))
+    if err != nil || string(b) != "after restart\n" { t.Fatalf("expected the write in -3 (full -2 skipped): %q %v", b, err) }
+    if st, _ := os.Stat(filepath.Join(dir, "adapter-"+today+"-2.log")); st.Size() != 100 { t.Fatalf("oversized file appended to: %d bytes", st.Size()) }
+}
+
+func TestRotatingWriter_LocationDrivesFilename(t *testing.T) {
+    dir := t.TempDir()
+    // 20:00 UTC on Jan 1 is already Jan 2 in Tokyo (UTC+9)
+    clock := func() time.Time { return time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC) }
+    tokyo := time.FixedZone("JST", 9*3600)
+
+    w, err := apilog.NewRotatingWriterOptions(filepath.Join(dir, "tz.log"), apilog.RotatingOptions{Location: tokyo, Now: clock})
+    if err != nil { t.Fatalf("NewRotatingWriterOptions: %v", err) }
+    w.Close()
+    if _, err := os.Stat(filepath.Join(dir, "tz-2024-01-02.log")); err != nil { t.Fatalf("expected the Tokyo date in the filename: %v", err) }
+
+    w, err = apilog.NewRotatingWriterOptions(filepath.Join(dir, "utc.log"), apilog.RotatingOptions{Now: clock})
+    if err != nil { t.Fatalf("NewRotatingWriterOptions: %v", err) }
+    w.Close()
+    if _, err := os.Stat(filepath.Join(dir, "utc-2024-01-01.log")); err != nil { t.Fatalf("expected the UTC date by default: %v", err) }
+}
+
+func TestRotatingWriter_SymlinkFollowsCurrentFile(t *testing.T) {
+    if runtime.GOOS == "windows" { t.Skip("symlinks need extra privileges on Windows") }
+    dir := t.TempDir()
+    base := filepath.Join(dir, "adapter.log")
+    os.WriteFile(base, []byte("current log file: old\n"), 0o644) // a pointer file left by an older version
+    w, err := apilog.NewRotatingWriterOptions(base, apilog.RotatingOptions{MaxBytes: 10, Symlink: true})
+    if err != nil { t.Fatalf("NewRotatingWriterOptions: %v", err) }
+    defer w.Close()
+
+    for _, line := range []string{"first line\n", "second line\n"} {
+        if _, err := w.Write([]byte(line)); err != nil { t.Fatalf("Write: %v", err) }
+        fi, err := os.Lstat(base)
+        if err != nil || fi.Mode()&os.ModeSymlink == 0 { t.Fatalf("base is not a symlink: %v %v", fi, err) }
+        got, err := os.ReadFile(base)
+        if err != nil || string(got) != line { t.Fatalf("symlink reads %q (%v), want the current file %q", got, err, line) }
+    }
+    if _, err := os.Stat(base + ".tmp"); !os.IsNotExist(err) { t.Fatalf("temporary link left behind: %v", err) }
+}
+
+func TestRotatingWriter_CompressesLeftoversThenPrunes(t *testing.T) {
+    dir := t.TempDir()
+    day := func(n int) string { return time.Now().UTC().AddDate(0, 0, -n).Format("2006-01-02") }
+    // compressible leftovers of an earlier run: 2000 bytes raw, far less gzipped
+    for _, n := range []int{2, 1} {
+        if err := os.WriteFile(filepath.Join(dir, "adapter-"+day(n)+".log"), []byte(strings.Repeat("line\n", 400)), 0o644); err != nil { t.Fatal(err) }
+    }
+
+    w, err := apilog.NewRotatingWriterOptions(filepath.Join(dir, "adapter.log"), apilog.RotatingOptions{MaxTotalBytes: 1000, Compress: true})
+    if err != nil { t.Fatalf("NewRotatingWriterOptions: %v", err) }
+    if err := w.Close(); err != nil { t.Fatalf("close: %v", err) }
+
+    var left []string
+    entries, _ := os.ReadDir(dir)
+    for _, e := range entries { left = append(left, e.Name()) }
+    // pruning ran on the compressed sizes, so both leftovers fit the budget
+    want := []string{"adapter-" + day(2) + ".log.gz", "adapter-" + day(1) + ".log.gz", "adapter-" + day(0) + ".log", "adapter.log"}
+    sort.Strings(want)
+    if strings.Join(left, ",") != strings.Join(want, ",") { t.Fatalf("files after startup:\n got %v\nwant %v", left, want) }
+}